@@ -14,11 +14,22 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 
+	"laptudirm.com/x/brainfuck/pkg/instruction"
 	"laptudirm.com/x/brainfuck/pkg/lexer"
 	"laptudirm.com/x/brainfuck/pkg/parser"
+	"laptudirm.com/x/brainfuck/pkg/rules"
+	"laptudirm.com/x/brainfuck/pkg/targets"
+	"laptudirm.com/x/brainfuck/pkg/targets/amd64"
+	"laptudirm.com/x/brainfuck/pkg/targets/brainfuck"
+	"laptudirm.com/x/brainfuck/pkg/targets/c"
+	"laptudirm.com/x/brainfuck/pkg/targets/elf"
+	"laptudirm.com/x/brainfuck/pkg/targets/golang"
 	"laptudirm.com/x/brainfuck/pkg/targets/opcode"
 )
 
@@ -29,13 +40,58 @@ func main() {
 	}
 }
 
+const usage = `usage: brainfuck [run] [--remarks=text|json] [--rules=file] [--keep-trailing] [--ir] [--emit=ir|json] <file>
+       brainfuck ir [--remarks=text|json] [--rules=file] [--keep-trailing] [--ir] [--emit=ir|json] <file>
+       brainfuck stats [--remarks=text|json] [--rules=file] [--keep-trailing] [--ir] <file>
+       brainfuck build [--remarks=text|json] [--rules=file] [--keep-trailing] [--ir] [--target=elf|bf|c|go|amd64-asm] [--tape=n] [--eof=rule] [--lines] [--package=name] [-o file] <file>`
+
 func mainFunc() error {
-	if len(os.Args) != 2 {
-		return fmt.Errorf("usage: brainfuck <file>")
+	// the run command is the default
+	command, args := "run", os.Args[1:]
+	if len(args) > 0 && (args[0] == "run" || args[0] == "ir" || args[0] == "stats" || args[0] == "build") {
+		command, args = args[0], args[1:]
+	}
+
+	flags := flag.NewFlagSet("brainfuck "+command, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), usage)
+		flags.PrintDefaults()
+	}
+
+	remarks := flags.String("remarks", "", "print optimization remarks as `format` (text or json)")
+	rulesFile := flags.String("rules", "", "optimize loops using the rewrite rules in `file`")
+	keepTrailing := flags.Bool("keep-trailing", false, "keep the code at the end of the program which has no observable effect")
+	ir := flags.Bool("ir", false, "read the file as textual IR instead of brainfuck")
+	emit := flags.String("emit", "", "print the optimized program as `format` (ir or json) instead of running it")
+	target := flags.String("target", "elf", "compile the program to `target` (elf, bf, c, go, or amd64-asm) with the build command")
+	output := flags.String("o", "", "write the compiled program to `file` instead of stdout")
+	tape := flags.Int("tape", targets.DefaultTapeSize, "number of cells of memory of the compiled program")
+	eof := flags.String("eof", "unchanged", "what input stores in a cell on EOF in the compiled program (unchanged, zero, or minus-one)")
+	lines := flags.Bool("lines", false, "point the compiled C program back to the source with #line directives")
+	pkg := flags.String("package", os.Getenv("GOPACKAGE"), "`name` of the compiled Go package, $GOPACKAGE by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *emit != "" && (command == "stats" || command == "build") {
+		return fmt.Errorf("--emit can't be used with the %s command", command)
+	}
+
+	// the ir command emits textual IR by default
+	if command == "ir" && *emit == "" {
+		*emit = "ir"
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf(usage)
+	}
+
+	if *ir && (*remarks != "" || *rulesFile != "" || *keepTrailing) {
+		return fmt.Errorf("--remarks, --rules and --keep-trailing can't be used with --ir")
 	}
 
 	// extract filename
-	filename := os.Args[1]
+	filename := flags.Arg(0)
 
 	// read source code
 	source, err := os.ReadFile(filename)
@@ -43,15 +99,229 @@ func mainFunc() error {
 		return err
 	}
 
+	var ins *instruction.Chunk
+	var stats instruction.Stats
+	if *ir {
+		// textual IR is already optimized
+		if ins, err = instruction.ParseText(source); err != nil {
+			return err
+		}
+
+		stats = instruction.Measure(ins)
+	} else if ins, stats, err = build(source, *rulesFile, *remarks, *keepTrailing); err != nil {
+		return err
+	}
+
+	// warn about loops which never terminate
+	for _, w := range instruction.CheckTermination(ins) {
+		fmt.Fprintf(os.Stderr, "%s:%s\n", filename, w)
+	}
+
+	switch command {
+	case "stats":
+		printStats(os.Stdout, stats)
+		return nil
+
+	case "build":
+		rule, err := targets.ParseEOF(*eof)
+		if err != nil {
+			return err
+		}
+
+		o := options{TapeSize: *tape, EOF: rule, Package: *pkg}
+		if *lines {
+			o.Filename = filename
+		}
+
+		return compile(ins, *target, *output, o)
+	}
+
+	switch *emit {
+	case "":
+		// compile to opcode and run
+		oc := opcode.Compile(ins)
+		return opcode.Run(oc)
+
+	case "ir":
+		_, err := os.Stdout.Write(instruction.FormatText(ins))
+		return err
+
+	case "json":
+		e := json.NewEncoder(os.Stdout)
+		e.SetIndent("", "  ")
+		return e.Encode(ins)
+
+	default:
+		return fmt.Errorf("unknown emit format %q", *emit)
+	}
+}
+
+// build parses and optimizes the given brainfuck source, using the rewrite
+// rules in rulesFile, if any, and prints the optimization remarks to stderr
+// in the given format, if any. Trailing code with no observable effect is
+// kept if keepTrailing is set. It also returns the statistics of the
+// program.
+func build(source []byte, rulesFile, remarks string, keepTrailing bool) (*instruction.Chunk, instruction.Stats, error) {
+	builder := instruction.ChunkBuilder{KeepTrailing: keepTrailing}
+
+	// load rewrite rules
+	if rulesFile != "" {
+		src, err := os.ReadFile(rulesFile)
+		if err != nil {
+			return nil, instruction.Stats{}, err
+		}
+
+		r, err := rules.Parse(src)
+		if err != nil {
+			return nil, instruction.Stats{}, err
+		}
+
+		for _, rule := range r {
+			builder.Rules = append(builder.Rules, rule)
+		}
+	}
+
 	// parse source code
-	ins, err := parser.Parse(lexer.Lex(source))
+	ins, err := parser.ParseWith(lexer.Lex(source), &builder)
 	if err != nil {
+		return nil, instruction.Stats{}, err
+	}
+
+	// print optimization remarks
+	if remarks != "" {
+		if err := printRemarks(os.Stderr, builder.Remarks(), remarks); err != nil {
+			return nil, instruction.Stats{}, err
+		}
+	}
+
+	return ins, builder.Stats(), nil
+}
+
+// options are the options of all the compilation targets.
+type options struct {
+	TapeSize int
+	EOF      targets.EOF
+	Filename string // source file for #line directives, if any
+	Package  string // name of Go packages
+}
+
+// compile compiles the given chunk to the given target with the given
+// options, and writes it to the given file, or to stdout if it is empty.
+func compile(ins *instruction.Chunk, target, output string, o options) error {
+	if o.TapeSize <= 0 {
+		return fmt.Errorf("invalid tape size %d", o.TapeSize)
+	}
+
+	var dst []byte
+	mode := os.FileMode(0644)
+	switch target {
+	case "elf":
+		if output == "" {
+			return fmt.Errorf("the elf target can't be written to stdout, set the output file with -o")
+		}
+
+		if o.TapeSize > elf.MaxTapeSize {
+			return fmt.Errorf("invalid tape size %d, the elf target supports at most %d cells", o.TapeSize, elf.MaxTapeSize)
+		}
+
+		dst = elf.Compile(ins, elf.Options{TapeSize: o.TapeSize, EOF: o.EOF})
+		mode = 0755
+
+	case "bf":
+		dst = append(brainfuck.Compile(ins), '\n')
+
+	case "c":
+		dst = c.Compile(ins, c.Options{TapeSize: o.TapeSize, EOF: o.EOF, Filename: o.Filename})
+
+	case "go":
+		var err error
+		dst, err = golang.Compile(ins, golang.Options{Package: o.Package, TapeSize: o.TapeSize, EOF: o.EOF})
+		if err != nil {
+			return fmt.Errorf("%w, set the package name with --package", err)
+		}
+
+	case "amd64-asm":
+		dst = amd64.Compile(ins, amd64.Options{TapeSize: o.TapeSize, EOF: o.EOF})
+
+	default:
+		return fmt.Errorf("unknown target %q", target)
+	}
+
+	if output == "" {
+		_, err := os.Stdout.Write(dst)
+		return err
+	}
+
+	if err := os.WriteFile(output, dst, mode); err != nil {
 		return err
 	}
 
-	// compile to opcode and run
-	oc := opcode.Compile(ins)
-	opcode.Run(oc)
+	if mode&0111 != 0 {
+		// WriteFile doesn't change the mode of existing files
+		return os.Chmod(output, mode)
+	}
 
 	return nil
 }
+
+// printRemarks prints the given optimization remarks to w in the given
+// format, which is either text or json.
+func printRemarks(w io.Writer, remarks []instruction.Remark, format string) error {
+	switch format {
+	case "text":
+		for _, r := range remarks {
+			fmt.Fprintln(w, r)
+		}
+
+		return nil
+
+	case "json":
+		if remarks == nil {
+			remarks = []instruction.Remark{}
+		}
+
+		e := json.NewEncoder(w)
+		e.SetIndent("", "  ")
+		return e.Encode(remarks)
+
+	default:
+		return fmt.Errorf("unknown remarks format %q", format)
+	}
+}
+
+// kinds are the kinds of instructions in the order they are printed by
+// printStats.
+var kinds = []string{"Value", "Set", "Mul", "Input", "Output", "Print", "StartLoop", "EndLoop", "If", "EndIf"}
+
+// printStats prints the given statistics of a program to w.
+func printStats(w io.Writer, s instruction.Stats) {
+	fmt.Fprintf(w, "%-10s %7s %7s\n", "kind", "before", "after")
+	for _, k := range kinds {
+		if s.Before[k] == 0 && s.After[k] == 0 {
+			continue
+		}
+
+		before := "-" // unknown for textual IR
+		if s.Before != nil {
+			before = fmt.Sprint(s.Before[k])
+		}
+
+		fmt.Fprintf(w, "%-10s %7s %7d\n", k, before, s.After[k])
+	}
+
+	fmt.Fprintf(w, "loops: %d, %d optimized, %d remaining\n", s.Loops, s.OptimizedLoops, s.RemainingLoops)
+	fmt.Fprintf(w, "max depth: %d\n", s.MaxDepth)
+
+	tape := fmt.Sprintf("cells %d to %d", s.Tape.Min, s.Tape.Max)
+	switch {
+	case s.Tape.Left && s.Tape.Right:
+		tape = "unbounded"
+	case s.Tape.Left:
+		tape += ", unbounded to the left"
+	case s.Tape.Right:
+		tape += ", unbounded to the right"
+	}
+
+	fmt.Fprintf(w, "tape: %s\n", tape)
+	fmt.Fprintf(w, "reads input: %t\n", s.ReadsInput)
+}
@@ -13,13 +13,39 @@
 
 package instruction
 
+import "laptudirm.com/x/brainfuck/pkg/token"
+
 // ChunkBuilder is helper struct which is used to build an optimized
 // instruction Chunk. It's zero value is safe to use.
 type ChunkBuilder struct {
+	// KeepTrailing disables the removal of the code at the end of the
+	// program which has no observable effect. It should be set if the
+	// final state of the tape is needed, for example for tape dumps.
+	KeepTrailing bool
+
+	// Rules are user-defined rewrite rules which are applied to loops
+	// which the built-in optimizations can't optimize.
+	Rules []LoopRule
+
 	ins       []Instruction
 	loopStack []int
 	finalized bool
 	offset    int
+
+	pos     token.Position // source position of the next instruction
+	remarks remarks        // optimization remarks
+	before  Counts         // instructions before optimization
+}
+
+// LoopRule is a rewrite rule for loops which can be applied by the
+// ChunkBuilder in addition to it's built-in optimizations.
+type LoopRule interface {
+	// Rewrite returns the instructions which replace a loop with the given
+	// body, and true, or false if the rule doesn't apply to the loop. The
+	// body has no net pointer movement and contains no loops or ifs, and
+	// it's offsets, like those of the returned instructions, are relative
+	// to the loop's control cell.
+	Rewrite(body []Instruction) ([]Instruction, bool)
 }
 
 // Finalize signals that the chunk has been built and no more instructions
@@ -32,7 +58,22 @@ func (c *ChunkBuilder) Finalize() *Chunk {
 
 	// mark chunk as finalized
 	c.finalized = true
-	return &Chunk{ins: c.ins}
+
+	// run optimization passes over the whole chunk
+	c.ins = foldKnownValues(c.ins, &c.remarks)
+	c.ins = eliminateDeadStores(c.ins)
+
+	// removing dead stores can make Sets redundant, like the Set 0 of a
+	// folded multiplication loop at the start of the program
+	c.ins = foldKnownValues(c.ins, &c.remarks)
+
+	if !c.KeepTrailing {
+		c.ins = removeTrailing(c.ins, &c.remarks)
+	}
+
+	chunk := &Chunk{ins: c.ins}
+	VerifyDebug(chunk) // catch bugs in the optimizer
+	return chunk
 }
 
 // IsFinalized informs whether the chunk has been finalized or not.
@@ -40,6 +81,38 @@ func (c *ChunkBuilder) IsFinalized() bool {
 	return c.finalized
 }
 
+// Remarks returns the remarks made by the optimizer while building the
+// chunk, sorted by the positions of the loops they are about.
+func (c *ChunkBuilder) Remarks() []Remark {
+	return c.remarks.sorted()
+}
+
+// SetPosition sets the source position of the instructions which are
+// added after it, which is used to refer back to the source code.
+func (c *ChunkBuilder) SetPosition(pos token.Position) {
+	c.pos = pos
+}
+
+// spans returns the spans of an instruction derived from the current
+// command, if it's position is known.
+func (c *ChunkBuilder) spans() Spans {
+	if c.pos == (token.Position{}) {
+		return nil
+	}
+
+	return Spans{{Start: c.pos, End: c.pos}}
+}
+
+// count records that an instruction of the given kind was given to the
+// builder, before it was optimized.
+func (c *ChunkBuilder) count(kind string) {
+	if c.before == nil {
+		c.before = make(Counts)
+	}
+
+	c.before[kind]++
+}
+
 // CanFinalize informs whether Finalize can be called without panicking.
 func (c *ChunkBuilder) CanFinalize() bool {
 	return len(c.loopStack) == 0
@@ -49,7 +122,8 @@ func (c *ChunkBuilder) CanFinalize() bool {
 // chunk, with the current offsets in mind.
 func (c *ChunkBuilder) ChangeValue(by int8) {
 	c.assertNotFinalized() // make sure chunk is not finalized
-	c.optimizedPush(Value{X: byte(by), Offset: c.offset})
+	c.count("Value")
+	c.optimizedPush(Value{X: byte(by), Offset: c.offset, Spans: c.spans()})
 }
 
 // ChangePointer is a helper function which represents adding a pointer
@@ -63,24 +137,27 @@ func (c *ChunkBuilder) ChangePointer(change int) {
 // chunk, with the current offsets in mind.
 func (c *ChunkBuilder) InputByte() {
 	c.assertNotFinalized() // make sure chunk is not finalized
-	c.optimizedPush(Input{Offset: c.offset})
+	c.count("Input")
+	c.optimizedPush(Input{Offset: c.offset, Spans: c.spans()})
 }
 
 // OutputByte is a helper function for adding a Output instruction to the
 // chunk, with the current offsets in mind.
 func (c *ChunkBuilder) OutputByte() {
 	c.assertNotFinalized() // make sure chunk is not finalized
-	c.push(Output{Offset: c.offset})
+	c.count("Output")
+	c.push(Output{Offset: c.offset, Spans: c.spans()})
 }
 
 // StartLoop is a helper function for adding a StartLoop instruction to
 // the chunk, with the current offsets in mind.
 func (c *ChunkBuilder) StartLoop() {
 	c.assertNotFinalized() // make sure chunk is not finalized
+	c.count("StartLoop")
 
-	c.loopStack = append(c.loopStack, len(c.ins)) // add to loop stack
-	c.push(StartLoop{Offset: c.offset})           // push start loop
-	c.offset = 0                                  // reset offset count
+	c.loopStack = append(c.loopStack, len(c.ins))         // add to loop stack
+	c.push(StartLoop{Offset: c.offset, Spans: c.spans()}) // push start loop
+	c.offset = 0                                          // reset offset count
 }
 
 // EndLoop is a helper function which encapsulates adding a EndLoop
@@ -92,6 +169,8 @@ func (c *ChunkBuilder) EndLoop() {
 		panic("chunk builder: unexpected EndLoop")
 	}
 
+	c.count("EndLoop")
+
 	last := len(c.loopStack) - 1     // last index of loopStack
 	start := c.loopStack[last]       // last element of loopStack
 	c.loopStack = c.loopStack[:last] // remove last element
@@ -99,35 +178,104 @@ func (c *ChunkBuilder) EndLoop() {
 	body := c.ins[start+1:]
 	offset := c.ins[start].MemOffset()
 
+	// spans of the start and the end of the loop
+	opening, closing := c.ins[start].(StartLoop).Spans, c.spans()
+	pos := opening.Pos()
+
+	// innermost loop bodies are a single basic block, so they can be
+	// cleaned up before trying to optimize the loop
+	if !hasLoop(body) {
+		body = eliminateDeadStores(body)
+		c.ins = append(c.ins[:start+1], body...)
+	}
+
 	// remove loops which are never executed
 	if c.isRedundantLoop(start, offset) {
+		c.remarks.remove(opening, closing, "removed: never executed")
 		c.ins = c.ins[:start] // clear instruction slice
 		c.offset = offset     // reset current offset
 		return
 	}
 
 	// check if the loop body can be optimized
-	if i, ok := optimizeLoopBody(body, offset, c.offset); ok {
+	i, ok := optimizeLoopBody(body, offset, c.offset)
+	if !ok {
+		i, ok = c.applyRules(body, offset)
+	}
+
+	if ok {
+		c.remarks.add(pos, RemarkOptimized, "converted to %s", describe(i))
 		c.ins = c.ins[:start] // remove loop body
-		c.put(i...)           // put optimized code
+
+		// put optimized code, which is derived from the whole loop
+		for _, optimized := range i {
+			c.put(withSpans(optimized, spanning(opening, closing)))
+		}
 
 		// since the loop has been optimized, integrate it into the offset
 		c.offset = offset
 		return
 	}
 
+	// balanced loops don't need to move the pointer, so address their
+	// bodies relative to the enclosing frame
+	if c.offset == 0 && isBalanced(body) {
+		for n := start + 1; n < len(c.ins); n++ {
+			c.ins[n] = shift(c.ins[n], offset)
+		}
+
+		// loops which clear their control cell are executed at most once
+		if clearsCell(c.ins[start+1:], offset) {
+			c.remarks.add(pos, RemarkOptimized, "converted to if: control cell cleared by body")
+			c.ins[start] = If{Offset: offset, Spans: opening}
+			c.push(EndIf{Offset: offset, Spans: closing})
+			c.offset = offset
+			return
+		}
+
+		c.remarks.add(pos, RemarkMissed, "not optimized: %s, pointer movement hoisted", whyNotOptimized(body, c.offset))
+
+		c.ins[start] = StartLoop{Offset: offset, Balanced: true, Spans: opening}
+		c.push(EndLoop{Offset: offset, Balanced: true, Spans: closing})
+		c.offset = offset
+		return
+	}
+
 	// optimization failed, standard loop
-	c.push(EndLoop{Offset: c.offset})
+	c.remarks.add(pos, RemarkMissed, "not optimized: %s", whyNotOptimized(body, c.offset))
+	c.push(EndLoop{Offset: c.offset, Spans: closing})
 	c.offset = 0
 }
 
+// applyRules tries to rewrite the loop with the given body, whose control
+// cell is at the given offset, using the user-defined rules. If any of them
+// apply, it returns the rewritten instructions and true.
+func (c *ChunkBuilder) applyRules(body []Instruction, offset int) ([]Instruction, bool) {
+	if c.offset != 0 || hasBranch(body) {
+		// rules only apply to simple loops
+		return nil, false
+	}
+
+	for _, r := range c.Rules {
+		if i, ok := r.Rewrite(body); ok {
+			for n := range i {
+				i[n] = shift(i[n], offset)
+			}
+
+			return i, true
+		}
+	}
+
+	return nil, false
+}
+
 // isRedundantLoop checks if a loop starting at the given position in the
 // instruction chunk is redundant or not.
 //
 // Loops which are before any other instruction are redundant as all cells
 // are 0 by default. Loops which start right after the end of another loop
-// or a Clear instruction are redundant as the previous loop only exits
-// when the cell is zero.
+// or if, or a Clear instruction are redundant as the previous loop only
+// exits when the cell is zero.
 func (c *ChunkBuilder) isRedundantLoop(pos, offset int) bool {
 	if pos == 0 {
 		return true
@@ -142,7 +290,7 @@ func (c *ChunkBuilder) isRedundantLoop(pos, offset int) bool {
 	switch v := ins.(type) {
 	case Set:
 		return v.X == 0
-	case EndLoop:
+	case EndLoop, EndIf:
 		return true
 	default:
 		return false
@@ -201,7 +349,7 @@ func (c *ChunkBuilder) optimizedPush(i Instruction) {
 			case Value:
 				c.pop()
 				if t := prev.X + curr.X; t != 0 {
-					c.push(Value{X: t, Offset: curr.MemOffset()})
+					c.push(Value{X: t, Offset: curr.MemOffset(), Spans: prev.merge(curr.Spans)})
 				}
 
 				return
@@ -209,15 +357,16 @@ func (c *ChunkBuilder) optimizedPush(i Instruction) {
 			// merge Value instructions into the Set instruction
 			case Set:
 				c.pop()
-				c.push(Set{X: prev.X + curr.X, Offset: curr.MemOffset()})
+				c.push(Set{X: prev.X + curr.X, Offset: curr.MemOffset(), Spans: prev.merge(curr.Spans)})
 				return
 			}
 
-		case Set, Input:
-			// Clear and Input instructions make any adjacent Value, Clear, or Input
-			// instructions redundant
+		case Set:
+			// Set instructions make any adjacent Value or Set instructions
+			// redundant. Input instructions are never removed since they
+			// consume a byte of input.
 			switch c.last().(type) {
-			case Value, Set, Input:
+			case Value, Set:
 				c.pop()
 			}
 		}
@@ -232,6 +381,113 @@ func (c *ChunkBuilder) push(i ...Instruction) {
 	c.ins = append(c.ins, i...)
 }
 
+// hasLoop checks if the given instructions contain a loop.
+func hasLoop(ins []Instruction) bool {
+	for _, i := range ins {
+		if _, ok := i.(StartLoop); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isBalanced checks if the given loop body has no net pointer movement,
+// assuming that the offset at the end of the body is 0. This is true only
+// if all the loops inside the body are also balanced.
+func isBalanced(body []Instruction) bool {
+	for _, i := range body {
+		if s, ok := i.(StartLoop); ok && !s.Balanced {
+			return false
+		}
+	}
+
+	return true
+}
+
+// clearsCell checks if the given balanced loop body always sets the cell
+// at the given offset to zero, and doesn't change it afterwards.
+func clearsCell(body []Instruction, offset int) bool {
+	depth := 0
+
+	// find the last write to the cell
+	for n := len(body) - 1; n >= 0; n-- {
+		switch v := body[n].(type) {
+		case EndLoop, EndIf:
+			depth++
+		case StartLoop, If:
+			depth--
+		case Value, Set, Input, Mul:
+			if v.MemOffset() != offset {
+				break
+			}
+
+			// writes inside nested loops or ifs are conditional
+			s, ok := v.(Set)
+			return depth == 0 && ok && s.X == 0
+		}
+	}
+
+	return false
+}
+
+// whyNotOptimized returns the reason why the given loop body, with the
+// given offset at the end of the body, could not be optimized.
+func whyNotOptimized(body []Instruction, end int) string {
+	if end != 0 || !isBalanced(body) {
+		return "unbalanced pointer movement"
+	}
+
+	for _, i := range body {
+		switch i.(type) {
+		case StartLoop, If:
+			return "contains nested loops"
+		case Input, Output:
+			return "performs input or output"
+		}
+	}
+
+	return "body is not a recognized idiom"
+}
+
+// shift returns the given instruction with it's offset changed by the
+// given amount.
+func shift(i Instruction, by int) Instruction {
+	switch v := i.(type) {
+	case Value:
+		v.Offset += by
+		return v
+	case Set:
+		v.Offset += by
+		return v
+	case Input:
+		v.Offset += by
+		return v
+	case Output:
+		v.Offset += by
+		return v
+	case Mul:
+		v.Source += by
+		v.Offset += by
+		return v
+	case StartLoop:
+		v.Offset += by
+		return v
+	case EndLoop:
+		v.Offset += by
+		return v
+	case If:
+		v.Offset += by
+		return v
+	case EndIf:
+		v.Offset += by
+		return v
+	default:
+		// instruction doesn't access memory
+		return i
+	}
+}
+
 // optimizeLoopBody tries to optimize the given instructions which were
 // found inside a loop. If successful, it returns the optimized
 // instructions and true, other wise it returns nil and false.
@@ -242,16 +498,65 @@ func optimizeLoopBody(i []Instruction, start, end int) ([]Instruction, bool) {
 		case 0:
 			// empty loop
 		case 1:
-			// repeated changes to the value will just
-			// loop until the current cell becomes 0
-			if v, ok := i[0].(Value); ok {
+			// repeated changes to the value will just loop until the
+			// current cell becomes 0, if they are odd, since even changes
+			// never clear an odd cell
+			if v, ok := i[0].(Value); ok && v.Offset == 0 && v.X%2 == 1 {
 				return []Instruction{Set{X: 0, Offset: start + v.Offset}}, true
 			}
 		default:
-			// TODO: more loop optimizations
+			// multiplication loops, like [->++<]
+			return multiplyLoop(i, start)
 		}
 	}
 
 	// no optimizations found
 	return nil, false
 }
+
+// multiplyLoop converts the given balanced loop body, which only changes
+// the values of cells, and changes the current cell by an odd amount each
+// iteration, into Mul instructions. Such a loop always terminates, and is
+// executed x * k times modulo 256, where x is the value of the current cell
+// and k is the inverse of the negated change.
+func multiplyLoop(body []Instruction, start int) ([]Instruction, bool) {
+	var delta byte
+	for _, i := range body {
+		v, ok := i.(Value)
+		if !ok {
+			return nil, false
+		}
+
+		if v.Offset == 0 {
+			delta += v.X
+		}
+	}
+
+	if delta%2 == 0 {
+		// the loop may never terminate
+		return nil, false
+	}
+
+	k := inverse(-delta)
+
+	var ins []Instruction
+	for _, i := range body {
+		if v := i.(Value); v.Offset != 0 {
+			ins = append(ins, Mul{X: v.X * k, Source: start, Offset: start + v.Offset})
+		}
+	}
+
+	return append(ins, Set{X: 0, Offset: start}), true
+}
+
+// inverse returns the multiplicative inverse of the given odd byte modulo
+// 256. Every odd byte is it's own inverse modulo 8, and each step of
+// Newton's method doubles the number of correct bits.
+func inverse(x byte) byte {
+	y := x
+	for n := 0; n < 2; n++ {
+		y *= 2 - x*y
+	}
+
+	return y
+}
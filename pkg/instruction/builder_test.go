@@ -0,0 +1,500 @@
+package instruction_test
+
+import (
+	"reflect"
+	"testing"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+	"laptudirm.com/x/brainfuck/pkg/lexer"
+	"laptudirm.com/x/brainfuck/pkg/parser"
+	"laptudirm.com/x/brainfuck/pkg/token"
+)
+
+// build builds a chunk from the given brainfuck source using a
+// ChunkBuilder which keeps trailing code, so that the optimizations on
+// it can be tested.
+func build(src string) *instruction.Chunk {
+	return buildWith(&instruction.ChunkBuilder{KeepTrailing: true}, src)
+}
+
+// buildWith builds a chunk from the given brainfuck source using the given
+// ChunkBuilder, ignoring any non-command characters.
+func buildWith(c *instruction.ChunkBuilder, src string) *instruction.Chunk {
+	for _, r := range src {
+		switch r {
+		case '+':
+			c.ChangeValue(1)
+		case '-':
+			c.ChangeValue(-1)
+		case '>':
+			c.ChangePointer(1)
+		case '<':
+			c.ChangePointer(-1)
+		case ',':
+			c.InputByte()
+		case '.':
+			c.OutputByte()
+		case '[':
+			c.StartLoop()
+		case ']':
+			c.EndLoop()
+		}
+	}
+
+	return c.Finalize()
+}
+
+// instructions returns the instructions in the given chunk as a slice.
+func instructions(c *instruction.Chunk) []instruction.Instruction {
+	ins := make([]instruction.Instruction, c.Len())
+	for i := range ins {
+		ins[i] = c.Instruction(i)
+	}
+
+	return ins
+}
+
+// optimizeTest represents a test of the optimizations performed by the
+// ChunkBuilder on some brainfuck source.
+type optimizeTest struct {
+	src string
+	out []instruction.Instruction
+}
+
+// runOptimizeTests builds the source of each test and compares the result
+// with the expected instructions.
+func runOptimizeTests(t *testing.T, tests []optimizeTest) {
+	t.Helper()
+
+	for _, test := range tests {
+		ins := instructions(build(test.src))
+		if !reflect.DeepEqual(ins, test.out) {
+			t.Errorf("%s: expected %v, received %v", test.src, test.out, ins)
+		}
+	}
+}
+
+var deadStoreTests = []optimizeTest{
+	{
+		src: ",>,<+>+<[-]>.",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.Input{Offset: 1},
+			instruction.Value{X: 1, Offset: 1},
+			instruction.Output{Offset: 1},
+			instruction.Set{X: 0, Offset: 0},
+		},
+	},
+	{
+		src: ",>,<+>+<+.",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.Input{Offset: 1},
+			instruction.Value{X: 2, Offset: 0},
+			instruction.Output{Offset: 0},
+			instruction.Value{X: 1, Offset: 1},
+		},
+	},
+	{
+		src: ",+,.",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.Value{X: 1, Offset: 0},
+			instruction.Input{Offset: 0},
+			instruction.Output{Offset: 0},
+		},
+	},
+	{
+		src: ",[>+<->-<--]",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.Set{X: 0, Offset: 0},
+		},
+	},
+}
+
+func TestDeadStores(t *testing.T) {
+	runOptimizeTests(t, deadStoreTests)
+}
+
+var knownValueTests = []optimizeTest{
+	{
+		src: ",[-]+.",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.Print{X: 1},
+			instruction.Set{X: 1, Offset: 0},
+		},
+	},
+	{
+		src: "++++[-]>+<[.]",
+		out: []instruction.Instruction{
+			instruction.Set{X: 1, Offset: 1},
+		},
+	},
+	{
+		src: ",[>+<-]>[-]<[.]>+.",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.Mul{X: 1, Source: 0, Offset: 1},
+			instruction.Print{X: 1},
+			instruction.Set{X: 0, Offset: 0},
+			instruction.Set{X: 1, Offset: 1},
+		},
+	},
+	{
+		src: ",[-]>>[<+>-]<+.",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.Print{X: 1},
+			instruction.Set{X: 0, Offset: 0},
+			instruction.Set{X: 1, Offset: 1},
+		},
+	},
+
+	// the changes are zero, so nothing is emitted
+	{
+		src: ",>[-<+>]<.",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.Output{Offset: 0},
+		},
+	},
+	{
+		src: ",>++++++++[<++++++++++++++++++++++++++++++++>-]<.",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.Output{Offset: 0},
+		},
+	},
+}
+
+func TestKnownValues(t *testing.T) {
+	runOptimizeTests(t, knownValueTests)
+}
+
+var balancedLoopTests = []optimizeTest{
+	{
+		src: ",>,[<+>]<.",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.Input{Offset: 1},
+			instruction.StartLoop{Offset: 1, Balanced: true},
+			instruction.Value{X: 1, Offset: 0},
+			instruction.EndLoop{Offset: 1, Balanced: true},
+			instruction.Output{Offset: 0},
+		},
+	},
+	{
+		src: ",>,<[>[->+<]<-]",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.Input{Offset: 1},
+			instruction.StartLoop{Offset: 0, Balanced: true},
+			instruction.Mul{X: 1, Source: 1, Offset: 2},
+			instruction.Set{X: 0, Offset: 1},
+			instruction.Value{X: 255, Offset: 0},
+			instruction.EndLoop{Offset: 0, Balanced: true},
+		},
+	},
+	{
+		src: ",>>,[<[<]>>]>+.",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.Input{Offset: 2},
+			instruction.StartLoop{Offset: 2},
+			instruction.StartLoop{Offset: -1},
+			instruction.EndLoop{Offset: -1},
+			instruction.EndLoop{Offset: 2},
+			instruction.Value{X: 1, Offset: 1},
+			instruction.Output{Offset: 1},
+		},
+	},
+	{
+		src: ",>+<[>.<-]>.",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.Set{X: 1, Offset: 1},
+			instruction.StartLoop{Offset: 0, Balanced: true},
+			instruction.Print{X: 1},
+			instruction.Value{X: 255, Offset: 0},
+			instruction.EndLoop{Offset: 0, Balanced: true},
+			instruction.Print{X: 1},
+		},
+	},
+}
+
+func TestBalancedLoops(t *testing.T) {
+	runOptimizeTests(t, balancedLoopTests)
+}
+
+var ifTests = []optimizeTest{
+	{
+		src: ",[>+.<[-]]",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.If{Offset: 0},
+			instruction.Print{X: 1},
+			instruction.Set{X: 1, Offset: 1},
+			instruction.Set{X: 0, Offset: 0},
+			instruction.EndIf{Offset: 0},
+		},
+	},
+	{
+		src: ",[>+.<[-]+]",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.StartLoop{Offset: 0, Balanced: true},
+			instruction.Value{X: 1, Offset: 1},
+			instruction.Output{Offset: 1},
+			instruction.Set{X: 1, Offset: 0},
+			instruction.EndLoop{Offset: 0, Balanced: true},
+		},
+	},
+	{
+		src: "+[->+.<]>.",
+		out: []instruction.Instruction{
+			instruction.Print{X: 1},
+			instruction.Print{X: 1},
+			instruction.Set{X: 1, Offset: 1},
+		},
+	},
+}
+
+func TestIfs(t *testing.T) {
+	runOptimizeTests(t, ifTests)
+}
+
+var unrollTests = []optimizeTest{
+	{
+		src: "++++++++[>++++++++<-]",
+		out: []instruction.Instruction{
+			instruction.Set{X: 64, Offset: 1},
+		},
+	},
+	{
+		src: ",>--[<+>--]<.",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.Value{X: 127, Offset: 0},
+			instruction.Output{Offset: 0},
+		},
+	},
+	{
+		src: ",>+++[<.>-]",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.Output{Offset: 0},
+			instruction.Output{Offset: 0},
+			instruction.Output{Offset: 0},
+		},
+	},
+	{
+		src: ",>----[<.>-]",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.Set{X: 252, Offset: 1},
+			instruction.StartLoop{Offset: 1, Balanced: true},
+			instruction.Output{Offset: 0},
+			instruction.Value{X: 255, Offset: 1},
+			instruction.EndLoop{Offset: 1, Balanced: true},
+		},
+	},
+}
+
+func TestUnroll(t *testing.T) {
+	runOptimizeTests(t, unrollTests)
+}
+
+var trailingTests = []optimizeTest{
+	{
+		src: ",>+<[-]>.>+++<<[-]",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.Print{X: 1},
+		},
+	},
+	{
+		src: ",[.>+<-]>[->+<]>[-<+>]",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0},
+			instruction.StartLoop{Offset: 0, Balanced: true},
+			instruction.Output{Offset: 0},
+			instruction.Value{X: 1, Offset: 1},
+			instruction.Value{X: 255, Offset: 0},
+			instruction.EndLoop{Offset: 0, Balanced: true},
+		},
+	},
+	{
+		src: ".,>+<[>]",
+		out: []instruction.Instruction{
+			instruction.Print{X: 0},
+			instruction.Input{Offset: 0},
+			instruction.Set{X: 1, Offset: 1},
+			instruction.StartLoop{Offset: 0},
+			instruction.EndLoop{Offset: 1},
+		},
+	},
+	{
+		src: ".,[>++<--]",
+		out: []instruction.Instruction{
+			instruction.Print{X: 0},
+			instruction.Input{Offset: 0},
+			instruction.StartLoop{Offset: 0, Balanced: true},
+			instruction.Value{X: 2, Offset: 1},
+			instruction.Value{X: 254, Offset: 0},
+			instruction.EndLoop{Offset: 0, Balanced: true},
+		},
+	},
+}
+
+func TestTrailing(t *testing.T) {
+	for _, test := range trailingTests {
+		ins := instructions(buildWith(&instruction.ChunkBuilder{}, test.src))
+		if !reflect.DeepEqual(ins, test.out) {
+			t.Errorf("%s: expected %v, received %v", test.src, test.out, ins)
+		}
+	}
+}
+
+func TestRemarks(t *testing.T) {
+	c := instruction.ChunkBuilder{KeepTrailing: true}
+
+	// ,[-]>[<]<[.]
+	c.InputByte()
+	c.SetPosition(token.Position{Line: 1, Column: 2})
+	c.StartLoop()
+	c.ChangeValue(-1)
+	c.EndLoop()
+	c.ChangePointer(1)
+	c.SetPosition(token.Position{Line: 1, Column: 6})
+	c.StartLoop()
+	c.ChangePointer(-1)
+	c.EndLoop()
+	c.ChangePointer(-1)
+	c.SetPosition(token.Position{Line: 1, Column: 10})
+	c.StartLoop()
+	c.OutputByte()
+	c.EndLoop()
+	c.Finalize()
+
+	// every loop has a single remark with the final outcome
+	expected := []instruction.Remark{
+		{Pos: token.Position{Line: 1, Column: 2}, Kind: instruction.RemarkOptimized, Message: "converted to Set 0 at 0"},
+		{Pos: token.Position{Line: 1, Column: 6}, Kind: instruction.RemarkRemoved, Message: "removed: cell known zero"},
+		{Pos: token.Position{Line: 1, Column: 10}, Kind: instruction.RemarkRemoved, Message: "removed: cell known zero"},
+	}
+
+	if remarks := c.Remarks(); !reflect.DeepEqual(remarks, expected) {
+		t.Errorf("expected %v, received %v", expected, remarks)
+	}
+
+	c = instruction.ChunkBuilder{KeepTrailing: true}
+
+	// ,[>,[.]<]>[-]<[>,[.]<]
+	c.InputByte()
+	c.SetPosition(token.Position{Line: 1, Column: 2})
+	c.StartLoop()
+	c.ChangePointer(1)
+	c.InputByte()
+	c.SetPosition(token.Position{Line: 1, Column: 5})
+	c.StartLoop()
+	c.OutputByte()
+	c.EndLoop()
+	c.ChangePointer(-1)
+	c.SetPosition(token.Position{Line: 1, Column: 9})
+	c.EndLoop()
+	c.ChangePointer(1)
+	c.SetPosition(token.Position{Line: 1, Column: 11})
+	c.StartLoop()
+	c.ChangeValue(-1)
+	c.EndLoop()
+	c.ChangePointer(-1)
+	c.SetPosition(token.Position{Line: 1, Column: 15})
+	c.StartLoop()
+	c.ChangePointer(1)
+	c.InputByte()
+	c.SetPosition(token.Position{Line: 1, Column: 18})
+	c.StartLoop()
+	c.OutputByte()
+	c.EndLoop()
+	c.ChangePointer(-1)
+	c.SetPosition(token.Position{Line: 1, Column: 22})
+	c.EndLoop()
+	c.Finalize()
+
+	// the remarks about loops inside removed loops are dropped
+	expected = []instruction.Remark{
+		{Pos: token.Position{Line: 1, Column: 2}, Kind: instruction.RemarkMissed, Message: "not optimized: performs input or output, pointer movement hoisted"},
+		{Pos: token.Position{Line: 1, Column: 5}, Kind: instruction.RemarkMissed, Message: "not optimized: performs input or output, pointer movement hoisted"},
+		{Pos: token.Position{Line: 1, Column: 11}, Kind: instruction.RemarkOptimized, Message: "converted to Set 0 at 1"},
+		{Pos: token.Position{Line: 1, Column: 15}, Kind: instruction.RemarkRemoved, Message: "removed: cell known zero"},
+	}
+
+	if remarks := c.Remarks(); !reflect.DeepEqual(remarks, expected) {
+		t.Errorf("expected %v, received %v", expected, remarks)
+	}
+}
+
+// remarkTests are programs whose loops are remarked on by more than one
+// pass, like the builder and both of the known value passes.
+var remarkTests = []string{
+	">->[,]-.-.",
+	".[[]+,,]",
+	"++[>+++<-]>[<+>-]<.",
+	"+++[>++<-]>[>+<-]>.[.-]",
+}
+
+func TestRemarksPerLoop(t *testing.T) {
+	for _, src := range remarkTests {
+		var c instruction.ChunkBuilder
+		if _, err := parser.ParseWith(lexer.Lex([]byte(src)), &c); err != nil {
+			t.Fatal(err)
+		}
+
+		seen := make(map[token.Position]bool)
+		for _, remark := range c.Remarks() {
+			if seen[remark.Pos] {
+				t.Errorf("%s: more than one remark about the loop at %s: %v", src, remark.Pos, c.Remarks())
+			}
+
+			seen[remark.Pos] = true
+		}
+	}
+}
+
+// span returns the spans between each pair of the given columns on the
+// first line.
+func span(columns ...int) instruction.Spans {
+	var s instruction.Spans
+	for n := 0; n < len(columns); n += 2 {
+		s = append(s, token.Span{
+			Start: token.Position{Line: 1, Column: columns[n]},
+			End:   token.Position{Line: 1, Column: columns[n+1]},
+		})
+	}
+
+	return s
+}
+
+func TestSpans(t *testing.T) {
+	src := ",+ +>++<[->+<]."
+	c := instruction.ChunkBuilder{KeepTrailing: true}
+	chunk, err := parser.ParseWith(lexer.Lex([]byte(src)), &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []instruction.Instruction{
+		instruction.Input{Offset: 0, Spans: span(1, 1)},
+		instruction.Value{X: 2, Offset: 0, Spans: span(2, 2, 4, 4)},
+		instruction.Set{X: 2, Offset: 1, Spans: span(6, 7)},
+		instruction.Mul{X: 1, Source: 0, Offset: 1, Spans: span(9, 14)},
+		instruction.Print{X: 0, Spans: span(15, 15)},
+		instruction.Set{X: 0, Offset: 0, Spans: span(9, 14)},
+	}
+
+	if ins := instructions(chunk); !reflect.DeepEqual(ins, expected) {
+		t.Errorf("%s: expected %v, received %v", src, expected, ins)
+	}
+}
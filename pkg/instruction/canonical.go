@@ -0,0 +1,144 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instruction
+
+import (
+	"crypto/sha256"
+	"sort"
+)
+
+// Canonical returns the canonical form of the given chunk, so that chunks
+// which only differ in ways which don't change their behavior have the
+// same canonical form. The canonical form:
+//
+//   - has no spans.
+//   - has it's offsets shifted so that the lowest cell accessed is at
+//     offset 0, if the program never accesses cells to the left of the
+//     cell the pointer starts at. The output of the program doesn't
+//     change, but it's memory is shifted.
+//   - has all the writes of each basic block to each cell, made by Value
+//     and Set instructions, combined into a single instruction, which is
+//     delayed until the cell is read, like by the optimizer.
+//   - has every run of consecutive Value and Set instructions sorted by
+//     their offsets, since they write to different cells.
+//
+// Offsets are not shifted if the cells accessed may extend without limit
+// to the left, or are to the left of the initial cell, since the tape is
+// bounded on the left, and the shifted program may run out of bounds when
+// the original doesn't, or the other way around. Finding the canonical
+// form of a canonical form doesn't change it.
+func Canonical(c *Chunk) *Chunk {
+	ins := make([]Instruction, len(c.ins))
+	for n, i := range c.ins {
+		ins[n] = withSpans(i, nil)
+	}
+
+	ins = eliminateDeadStores(ins)
+	ins = normalizeOffsets(ins)
+	sortWrites(ins)
+
+	return &Chunk{ins: ins}
+}
+
+// Hash returns the SHA-256 hash of the canonical form of the given chunk,
+// so chunks with the same canonical form have the same hash. The hash is
+// stable across versions, unless the textual form of chunks changes.
+func Hash(c *Chunk) [sha256.Size]byte {
+	return sha256.Sum256(FormatText(Canonical(c)))
+}
+
+// normalizeOffsets shifts the offsets of the initial frame of the given
+// instructions, i.e. the instructions before the first unbalanced loop
+// and the loop's entry offset, so that the lowest cell accessed by them
+// is at offset 0, which shifts all the later accesses too. Nothing is
+// shifted unless the lowest cell is known and not to the left of 0.
+func normalizeOffsets(ins []Instruction) []Instruction {
+	accessed, found, _ := blockRange(ins, make([]Range, len(ins)))
+	if !found || accessed.Left || accessed.Min <= 0 {
+		return ins
+	}
+
+	end, ok := initialFrame(ins)
+	if !ok {
+		return ins
+	}
+
+	for n := range ins[:end] {
+		ins[n] = shift(ins[n], -accessed.Min)
+	}
+
+	return ins
+}
+
+// initialFrame returns the number of instructions whose offsets are in the
+// initial frame of the given instructions. It returns false if the frame
+// changes inside a balanced loop or if, which is not possible for valid
+// chunks.
+func initialFrame(ins []Instruction) (int, bool) {
+	depth := 0
+	for n, i := range ins {
+		switch v := i.(type) {
+		case StartLoop:
+			if v.Balanced {
+				depth++
+				break
+			}
+
+			// the entry offset is in the initial frame
+			return n + 1, depth == 0
+
+		case If:
+			depth++
+
+		case EndLoop, EndIf:
+			depth--
+		}
+	}
+
+	return len(ins), true
+}
+
+// sortWrites sorts every run of consecutive Value and Set instructions in
+// the given instructions by their offsets, keeping the order of writes to
+// the same cell.
+func sortWrites(ins []Instruction) {
+	for start := 0; start < len(ins); {
+		end := start
+		for end < len(ins) && isWrite(ins[end]) {
+			end++
+		}
+
+		if end == start {
+			start++
+			continue
+		}
+
+		run := ins[start:end]
+		sort.SliceStable(run, func(i, j int) bool {
+			return run[i].MemOffset() < run[j].MemOffset()
+		})
+
+		start = end
+	}
+}
+
+// isWrite checks if the given instruction is a Value or a Set.
+func isWrite(i Instruction) bool {
+	switch i.(type) {
+	case Value, Set:
+		return true
+	default:
+		return false
+	}
+}
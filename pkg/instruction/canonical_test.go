@@ -0,0 +1,99 @@
+package instruction_test
+
+import (
+	"bytes"
+	"testing"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+)
+
+var canonicalTests = []struct {
+	a, b  string // textual IR
+	equal bool
+}{
+	{
+		// spans and the order of independent writes don't matter
+		a:     "Input@0\nInput@1 ; 1:2\nValue(1)@1\nSet(2)@0\nOutput@0",
+		b:     "Input@0\nInput@1 ; 3:4\nSet(2)@0\nValue(1)@1\nOutput@0",
+		equal: true,
+	},
+	{
+		// writes to the same cell are combined
+		a:     "Input@0\nValue(1)@0\nValue(1)@0\nOutput@0",
+		b:     "Input@0\nValue(2)@0\nOutput@0",
+		equal: true,
+	},
+	{
+		// programs which differ by a constant shift of the pointer are
+		// equal, as long as they don't access cells to it's left
+		a:     "Input@3\nStartLoop@2\nEndLoop@1\nOutput@0",
+		b:     "Input@1\nStartLoop@0\nEndLoop@1\nOutput@0",
+		equal: true,
+	},
+	{
+		a:     "Input@2\nIf@2\nValue(1)@3\nEndIf@2\nOutput@3",
+		b:     "Input@0\nIf@0\nValue(1)@1\nEndIf@0\nOutput@1",
+		equal: true,
+	},
+	{
+		// <,. moves out of bounds, while ,. doesn't
+		a:     "Input@-1\nOutput@-1",
+		b:     "Input@0\nOutput@0",
+		equal: false,
+	},
+	{
+		// >+>+[<]. prints 0, while +>+[<]. moves out of bounds, and the
+		// loops may move the pointer to the left without limit
+		a:     "Set(1)@1\nSet(1)@2\nStartLoop@2\nEndLoop@-1\nPrint(0)",
+		b:     "Set(1)@0\nSet(1)@1\nStartLoop@1\nEndLoop@-1\nPrint(0)",
+		equal: false,
+	},
+	{
+		// the offsets after the pointer is moved by a loop do
+		a:     "Input@0\nStartLoop@0\nEndLoop@1\nOutput@0",
+		b:     "Input@0\nStartLoop@0\nEndLoop@1\nOutput@1",
+		equal: false,
+	},
+	{
+		// writes before a read of the cell can't be moved after it
+		a:     "Input@0\nValue(1)@0\nOutput@0",
+		b:     "Input@0\nOutput@0\nValue(1)@0",
+		equal: false,
+	},
+}
+
+func TestCanonical(t *testing.T) {
+	for _, test := range canonicalTests {
+		a, err := instruction.ParseText([]byte(test.a))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := instruction.ParseText([]byte(test.b))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ca, cb := instruction.FormatText(instruction.Canonical(a)), instruction.FormatText(instruction.Canonical(b))
+		if bytes.Equal(ca, cb) != test.equal {
+			t.Errorf("%q, %q: expected equal %t, received canonical forms\n%s\n%s", test.a, test.b, test.equal, ca, cb)
+		}
+
+		if ha, hb := instruction.Hash(a), instruction.Hash(b); (ha == hb) != test.equal {
+			t.Errorf("%q, %q: expected equal hashes %t, received %x and %x", test.a, test.b, test.equal, ha, hb)
+		}
+	}
+
+	// the canonical form of a canonical form is the same
+	for _, src := range textTests {
+		c := instruction.Canonical(build(src))
+		if v := instruction.Verify(c); v != nil {
+			t.Errorf("%q: invalid canonical form %v", src, v)
+		}
+
+		once, twice := instruction.FormatText(c), instruction.FormatText(instruction.Canonical(c))
+		if !bytes.Equal(once, twice) {
+			t.Errorf("%q: canonical form changed from\n%s\nto\n%s", src, once, twice)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instruction
+
+// eliminateDeadStores is a dataflow pass which works on each basic block,
+// i.e. each run of instructions between loop boundaries, of the given
+// instructions. Writes to cells are delayed until the cell is read by an
+// Output, an Input, which leaves the cell unchanged on EOF, a Mul, or a
+// loop test, so writes which are overwritten before being read are
+// removed, and Values at the same offset are merged even when they are
+// separated by writes to other offsets.
+func eliminateDeadStores(ins []Instruction) []Instruction {
+	var b block
+	dst := make([]Instruction, 0, len(ins))
+
+	for _, i := range ins {
+		switch v := i.(type) {
+		case Value:
+			b.change(v.Offset, v.X, v.Spans)
+
+		case Set:
+			b.set(v.Offset, v.X, v.Spans)
+
+		case Input:
+			// the cell is left unchanged on EOF, so any pending write to
+			// it has to be done before the input
+			dst = b.flushCell(dst, v.Offset)
+			dst = append(dst, v)
+
+		case Output:
+			// the output reads only the cell being output
+			dst = b.flushCell(dst, v.Offset)
+			dst = append(dst, v)
+
+		case Mul:
+			// the multiplication reads the source cell and changes the
+			// destination cell, so both have to be up to date
+			dst = b.flushCell(dst, v.Source)
+			dst = b.flushCell(dst, v.Offset)
+			dst = append(dst, v)
+
+		case Print:
+			// prints don't read any cells
+			dst = append(dst, v)
+
+		default:
+			// loop boundaries and any unknown instructions end the block
+			dst = b.flush(dst)
+			dst = append(dst, i)
+		}
+	}
+
+	// end of the last block
+	return b.flush(dst)
+}
+
+// block represents the pending writes of a basic block which have not
+// been put into the instruction list yet.
+type block struct {
+	order   []int         // offsets in the order they were first written
+	pending map[int]write // pending write for each offset
+}
+
+// write represents the combined effect of writes to a single cell.
+type write struct {
+	set   bool  // whether the cell's value is overwritten
+	x     byte  // value or change in value
+	spans Spans // spans of the writes which have an effect
+}
+
+// change records that the cell at offset was changed by x by code from
+// the given spans.
+func (b *block) change(offset int, x byte, spans Spans) {
+	w, ok := b.get(offset)
+	w.x += x
+	w.spans = w.spans.merge(spans)
+
+	if !ok {
+		b.order = append(b.order, offset)
+	}
+	b.pending[offset] = w
+}
+
+// set records that the cell at offset was set to x by code from the given
+// spans, making any previous pending write to it dead.
+func (b *block) set(offset int, x byte, spans Spans) {
+	if _, ok := b.get(offset); !ok {
+		b.order = append(b.order, offset)
+	}
+
+	b.pending[offset] = write{set: true, x: x, spans: spans}
+}
+
+// discard removes any pending write to the cell at offset.
+func (b *block) discard(offset int) {
+	if _, ok := b.get(offset); !ok {
+		return
+	}
+
+	delete(b.pending, offset)
+	b.order = removeOffset(b.order, offset)
+}
+
+// get returns the pending write to the cell at the given offset, and
+// whether there was one.
+func (b *block) get(offset int) (write, bool) {
+	if b.pending == nil {
+		b.pending = make(map[int]write)
+	}
+
+	w, ok := b.pending[offset]
+	return w, ok
+}
+
+// flushCell appends the pending write to the cell at offset, if any, to
+// dst and returns the result.
+func (b *block) flushCell(dst []Instruction, offset int) []Instruction {
+	w, ok := b.get(offset)
+	if !ok {
+		return dst
+	}
+
+	b.discard(offset)
+	return w.append(dst, offset)
+}
+
+// flush appends all the pending writes to dst in the order they were first
+// written and returns the result.
+func (b *block) flush(dst []Instruction) []Instruction {
+	for _, offset := range b.order {
+		dst = b.pending[offset].append(dst, offset)
+	}
+
+	b.order = b.order[:0]
+	b.pending = nil
+	return dst
+}
+
+// append appends the instruction equivalent to the write to the cell at
+// offset to dst and returns the result.
+func (w write) append(dst []Instruction, offset int) []Instruction {
+	switch {
+	case w.set:
+		return append(dst, Set{X: w.x, Offset: offset, Spans: w.spans})
+	case w.x != 0:
+		return append(dst, Value{X: w.x, Offset: offset, Spans: w.spans})
+	default:
+		// changing a value by 0 is a no-op
+		return dst
+	}
+}
+
+// removeOffset removes the given offset from the offsets slice.
+func removeOffset(offsets []int, offset int) []int {
+	for i, o := range offsets {
+		if o == offset {
+			return append(offsets[:i], offsets[i+1:]...)
+		}
+	}
+
+	return offsets
+}
@@ -0,0 +1,104 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instruction
+
+// Editor is a mutable list of instructions, which is used to write passes
+// which transform chunks outside this package. The instructions can be
+// freely edited, and are only checked to be valid when they are converted
+// back into a chunk, so that loops can be rebuilt in multiple steps.
+//
+// Indices are the same as in a slice, and the methods of an Editor panic
+// if they are out of range.
+type Editor struct {
+	ins []Instruction
+}
+
+// NewEditor returns an Editor for a copy of the instructions of the given
+// chunk, which is not modified.
+func NewEditor(c *Chunk) *Editor {
+	return &Editor{ins: append([]Instruction(nil), c.ins...)}
+}
+
+// Len returns the number of instructions.
+func (e *Editor) Len() int {
+	return len(e.ins)
+}
+
+// Instruction returns the ith instruction.
+func (e *Editor) Instruction(i int) Instruction {
+	return e.ins[i]
+}
+
+// Replace replaces the instructions from start to end, excluding end, with
+// the given instructions.
+func (e *Editor) Replace(start, end int, ins ...Instruction) {
+	// copy the tail, since it may be overwritten by ins
+	tail := append([]Instruction(nil), e.ins[end:]...)
+	e.ins = append(append(e.ins[:start], ins...), tail...)
+}
+
+// Insert inserts the given instructions before the ith instruction, or at
+// the end if i is the number of instructions.
+func (e *Editor) Insert(i int, ins ...Instruction) {
+	e.Replace(i, i, ins...)
+}
+
+// Delete deletes the instructions from start to end, excluding end.
+func (e *Editor) Delete(start, end int) {
+	e.Replace(start, end)
+}
+
+// Match returns the index of the EndLoop or EndIf matching the StartLoop
+// or If at the index i, or the index of the StartLoop or If matching the
+// EndLoop or EndIf at the index i. It returns -1 if there is no match, or
+// if the ith instruction is none of those.
+func (e *Editor) Match(i int) int {
+	step := 0
+	switch e.ins[i].(type) {
+	case StartLoop, If:
+		step = 1
+	case EndLoop, EndIf:
+		step = -1
+	default:
+		return -1
+	}
+
+	depth := 0
+	for n := i; n >= 0 && n < len(e.ins); n += step {
+		switch e.ins[n].(type) {
+		case StartLoop, If:
+			depth += step
+		case EndLoop, EndIf:
+			depth -= step
+		}
+
+		if depth == 0 {
+			return n
+		}
+	}
+
+	return -1
+}
+
+// Chunk returns a chunk with a copy of the edited instructions. If the
+// instructions are not valid, it returns a *VerifyError instead, see
+// Verify.
+func (e *Editor) Chunk() (*Chunk, error) {
+	c := &Chunk{ins: append([]Instruction(nil), e.ins...)}
+	if v := Verify(c); len(v) > 0 {
+		return nil, &VerifyError{v}
+	}
+
+	return c, nil
+}
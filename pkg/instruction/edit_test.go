@@ -0,0 +1,79 @@
+package instruction_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+)
+
+func TestEditor(t *testing.T) {
+	chunk, err := instruction.ParseText([]byte(`
+Input@0
+If@0
+	Output@0
+	Set(0)@0
+EndIf@0
+Print(10)
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := instruction.NewEditor(chunk)
+	if m := e.Match(1); m != 4 {
+		t.Errorf("expected If to match 4, received %d", m)
+	}
+
+	if m := e.Match(4); m != 1 {
+		t.Errorf("expected EndIf to match 1, received %d", m)
+	}
+
+	if m := e.Match(2); m != -1 {
+		t.Errorf("expected Output to match -1, received %d", m)
+	}
+
+	// rebuild the if as a balanced loop, the intermediate states are
+	// allowed to be invalid
+	e.Replace(1, 2, instruction.StartLoop{Offset: 0, Balanced: true})
+	e.Delete(4, 5)
+	e.Insert(4, instruction.EndLoop{Offset: 0, Balanced: true})
+	e.Insert(e.Len(), instruction.Print{X: 33})
+
+	edited, err := e.Chunk()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []instruction.Instruction{
+		instruction.Input{Offset: 0},
+		instruction.StartLoop{Offset: 0, Balanced: true},
+		instruction.Output{Offset: 0},
+		instruction.Set{X: 0, Offset: 0},
+		instruction.EndLoop{Offset: 0, Balanced: true},
+		instruction.Print{X: 10},
+		instruction.Print{X: 33},
+	}
+
+	if ins := instructions(edited); !reflect.DeepEqual(ins, expected) {
+		t.Errorf("expected %v, received %v", expected, ins)
+	}
+
+	// the original chunk is not modified
+	if _, ok := chunk.Instruction(1).(instruction.If); chunk.Len() != 6 || !ok {
+		t.Errorf("original chunk modified: %v", instructions(chunk))
+	}
+
+	// invalid edits are reported
+	e.Delete(4, 5)
+	var verr *instruction.VerifyError
+	if _, err := e.Chunk(); !errors.As(err, &verr) {
+		t.Fatalf("expected a VerifyError, received %v", err)
+	}
+
+	violations := []instruction.Violation{{Index: 1, Message: "StartBalancedLoop@0 not closed"}}
+	if !reflect.DeepEqual(verr.Violations, violations) {
+		t.Errorf("expected %v, received %v", violations, verr.Violations)
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instruction
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Evaluator executes a Chunk directly, one instruction at a time. It is
+// meant to be obviously correct rather than fast, and is used as a
+// reference to test compilation targets against, independent of any
+// encoding of the instructions. The Memory field must be set before
+// calling Run.
+//
+// Cells are addressed by their offsets from the pointer, and every access
+// to a cell outside the memory is an error. The instructions are executed
+// as follows:
+//
+//	Value     the cell at Offset is changed by X, wrapping at 256
+//	Set       the cell at Offset is set to X
+//	Mul       the cell at Offset is changed by X times the cell at Source,
+//	          only if the cell at Source isn't zero, since the loop it was
+//	          made from is never entered in that case
+//	Input     a byte is read into the cell at Offset, which is left
+//	          unchanged on EOF
+//	Output    the cell at Offset is written
+//	Print     the byte X is written
+//	StartLoop the pointer is moved by Offset, and the loop is skipped if
+//	          the cell under it is zero
+//	EndLoop   the pointer is moved by Offset, and the loop is repeated from
+//	          the first instruction of it's body if the cell under it isn't
+//	          zero
+//	If        the body is skipped if the cell at Offset is zero
+//	EndIf     the cell at Offset must be zero, see ErrIfNotCleared
+//
+// If a StartLoop or EndLoop is Balanced, the pointer is not moved, and the
+// cell at Offset is tested instead.
+type Evaluator struct {
+	Memory  []byte // memory tape
+	Pointer int    // memory pointer
+
+	Input  io.Reader // input stream, the cell is unchanged on EOF
+	Output io.Writer // output stream
+
+	// MaxSteps is the maximum number of instructions which are executed
+	// before giving up, or 0 for no limit.
+	MaxSteps int
+}
+
+// Errors returned by the evaluator.
+var (
+	ErrOutOfBounds  = errors.New("instruction: cell out of bounds")
+	ErrMaxSteps     = errors.New("instruction: maximum number of steps exceeded")
+	ErrIfNotCleared = errors.New("instruction: cell not zero at the end of an if")
+)
+
+// Run executes the given chunk, which must be valid.
+func (e *Evaluator) Run(c *Chunk) error {
+	VerifyDebug(c)
+
+	// find matching loops and ifs
+	jumps := make([]int, len(c.ins))
+	var stack []int
+	for pc, i := range c.ins {
+		switch i.(type) {
+		case StartLoop, If:
+			stack = append(stack, pc)
+		case EndLoop, EndIf:
+			start := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			jumps[start], jumps[pc] = pc, start
+		}
+	}
+
+	steps := 0
+	for pc := 0; pc < len(c.ins); pc++ {
+		if steps++; e.MaxSteps > 0 && steps > e.MaxSteps {
+			return ErrMaxSteps
+		}
+
+		switch v := c.ins[pc].(type) {
+		case Value:
+			cell, err := e.cell(v.Offset)
+			if err != nil {
+				return err
+			}
+
+			*cell += v.X
+
+		case Set:
+			cell, err := e.cell(v.Offset)
+			if err != nil {
+				return err
+			}
+
+			*cell = v.X
+
+		case Mul:
+			source, err := e.cell(v.Source)
+			if err != nil {
+				return err
+			}
+
+			if *source == 0 {
+				// the destination isn't accessed
+				break
+			}
+
+			cell, err := e.cell(v.Offset)
+			if err != nil {
+				return err
+			}
+
+			*cell += v.X * *source
+
+		case Input:
+			cell, err := e.cell(v.Offset)
+			if err != nil {
+				return err
+			}
+
+			var b [1]byte
+			n, err := io.ReadFull(e.Input, b[:])
+			if n == 1 {
+				*cell = b[0]
+			} else if err != io.EOF {
+				return err
+			}
+
+		case Output:
+			cell, err := e.cell(v.Offset)
+			if err != nil {
+				return err
+			}
+
+			if _, err := e.Output.Write([]byte{*cell}); err != nil {
+				return err
+			}
+
+		case Print:
+			if _, err := e.Output.Write([]byte{v.X}); err != nil {
+				return err
+			}
+
+		case StartLoop:
+			cell, err := e.test(v.Offset, v.Balanced)
+			if err != nil {
+				return err
+			}
+
+			if *cell == 0 {
+				// skip the loop
+				pc = jumps[pc]
+			}
+
+		case EndLoop:
+			cell, err := e.test(v.Offset, v.Balanced)
+			if err != nil {
+				return err
+			}
+
+			if *cell != 0 {
+				// repeat the body
+				pc = jumps[pc]
+			}
+
+		case If:
+			cell, err := e.cell(v.Offset)
+			if err != nil {
+				return err
+			}
+
+			if *cell == 0 {
+				// skip the body
+				pc = jumps[pc]
+			}
+
+		case EndIf:
+			cell, err := e.cell(v.Offset)
+			if err != nil {
+				return err
+			}
+
+			if *cell != 0 {
+				return ErrIfNotCleared
+			}
+
+		default:
+			// unreachable for valid chunks
+			panic(fmt.Sprintf("instruction: run: invalid instruction type %T in chunk", v))
+		}
+	}
+
+	return nil
+}
+
+// cell returns the cell at the given offset from the pointer.
+func (e *Evaluator) cell(offset int) (*byte, error) {
+	if p := e.Pointer + offset; p >= 0 && p < len(e.Memory) {
+		return &e.Memory[p], nil
+	}
+
+	return nil, ErrOutOfBounds
+}
+
+// test returns the cell tested by a StartLoop or EndLoop with the given
+// offset, moving the pointer if the loop is not balanced.
+func (e *Evaluator) test(offset int, balanced bool) (*byte, error) {
+	if balanced {
+		return e.cell(offset)
+	}
+
+	e.Pointer += offset
+	return e.cell(0)
+}
@@ -0,0 +1,80 @@
+package instruction_test
+
+import (
+	"bytes"
+	"testing"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+)
+
+var evalTests = []struct {
+	src    string // textual IR
+	input  string
+	output string
+	err    error
+}{
+	{
+		src: `
+Input@0
+StartBalancedLoop@0
+	Output@0
+	Mul(2, 0)@1
+	Value(-1)@0
+EndBalancedLoop@0
+Output@1
+`,
+		input:  "\x03",
+		output: "\x03\x02\x01\x0c",
+	},
+	{
+		// the cell is unchanged on EOF
+		src:    "Set(7)@0\nInput@0\nOutput@0",
+		output: "\x07",
+	},
+	{
+		// unbalanced loops move the pointer
+		src:    "Set(1)@1\nSet(1)@2\nStartLoop@1\nPrint(46)\nEndLoop@1\nOutput@-1",
+		output: "..\x01",
+	},
+	{
+		// the destination isn't accessed if the source is zero
+		src: "Mul(1, 0)@-100",
+	},
+	{
+		src: "Set(1)@0\nMul(1, 0)@-100",
+		err: instruction.ErrOutOfBounds,
+	},
+	{
+		src: "Value(1)@0\nStartLoop@0\nEndLoop@0",
+		err: instruction.ErrMaxSteps,
+	},
+	{
+		src: "Set(1)@0\nIf@0\nEndIf@0",
+		err: instruction.ErrIfNotCleared,
+	},
+}
+
+func TestEvaluator(t *testing.T) {
+	for _, test := range evalTests {
+		chunk, err := instruction.ParseText([]byte(test.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var output bytes.Buffer
+		e := instruction.Evaluator{
+			Memory:   make([]byte, 16),
+			Input:    bytes.NewReader([]byte(test.input)),
+			Output:   &output,
+			MaxSteps: 100,
+		}
+
+		if err := e.Run(chunk); err != test.err {
+			t.Errorf("%q: expected error %v, received %v", test.src, test.err, err)
+		}
+
+		if output.String() != test.output {
+			t.Errorf("%q: expected output %q, received %q", test.src, test.output, output.String())
+		}
+	}
+}
@@ -19,18 +19,29 @@
 // defined in this package, and reject any others. The interpretation of
 // an instruction must also follow it's definition.
 //
-// Since an instruction chunk can only be created by the builder,
-// implementations may assume any *instruction.Chunk to be valid, i.e,
-// composed only of the specified instructions and with properly matched
-// loops.
+// Chunks created by the builder, ParseText, DecodeJSON, and an Editor
+// are always valid, i.e, composed only of the specified instructions and
+// with properly matched loops, see Verify. Chunks created in other ways,
+// like from a Tree which has been modified, can be checked using Verify.
+// Implementations may assume any *instruction.Chunk to be valid, but
+// should call VerifyDebug on the chunks they are given, so that invalid
+// chunks are caught in debug builds.
 package instruction
 
-import "fmt"
+import (
+	"fmt"
+
+	"laptudirm.com/x/brainfuck/pkg/token"
+)
 
 // Instruction represents a brainfuck instruction.
 type Instruction interface {
 	Instruction() string
 	MemOffset() int
+
+	// SourceSpans returns the spans of source code the instruction was
+	// derived from, which is implemented by embedding Spans.
+	SourceSpans() []token.Span
 }
 
 // Value instruction changes the value of the cell at the given offset from
@@ -38,6 +49,7 @@ type Instruction interface {
 type Value struct {
 	X      byte
 	Offset int
+	Spans
 }
 
 func (v Value) Instruction() string {
@@ -52,6 +64,7 @@ func (v Value) MemOffset() int {
 // it in the cell at the given offset from the current cell.
 type Input struct {
 	Offset int
+	Spans
 }
 
 func (i Input) Instruction() string {
@@ -66,6 +79,7 @@ func (i Input) MemOffset() int {
 // from the current cell as a string, i.e. 65 -> A.
 type Output struct {
 	Offset int
+	Spans
 }
 
 func (o Output) Instruction() string {
@@ -78,11 +92,21 @@ func (o Output) MemOffset() int {
 
 // StartLoop instruction signals the start of a loop, after moving the
 // pointer by the given offset.
+//
+// If the loop is Balanced, i.e. its body has no net pointer movement, the
+// pointer is not moved. Instead, the cell at the given offset is tested
+// and the body uses the same offsets as the code around the loop.
 type StartLoop struct {
-	Offset int
+	Offset   int
+	Balanced bool
+	Spans
 }
 
 func (s StartLoop) Instruction() string {
+	if s.Balanced {
+		return fmt.Sprintf("Start Balanced Loop at %d", s.Offset)
+	}
+
 	return fmt.Sprintf("Start Loop at %d", s.Offset)
 }
 
@@ -90,24 +114,69 @@ func (s StartLoop) MemOffset() int {
 	return s.Offset
 }
 
-// EndLoop instruction signals the end of a loop.
+// EndLoop instruction signals the end of a loop, after moving the pointer
+// by the given offset. If the loop is Balanced, the pointer is not moved
+// and the cell at the given offset is tested instead.
 type EndLoop struct {
-	Offset int
+	Offset   int
+	Balanced bool
+	Spans
 }
 
 func (e EndLoop) Instruction() string {
+	if e.Balanced {
+		return fmt.Sprintf("End Balanced Loop at %d", e.Offset)
+	}
+
 	return fmt.Sprintf("End Loop at %d", e.Offset)
 }
 
 func (e EndLoop) MemOffset() int {
+	if e.Balanced {
+		return e.Offset
+	}
+
+	// the pointer is at the tested cell
 	return 0
 }
 
+// If instruction signals the start of a block of instructions which is
+// only executed if the cell at the given offset is not zero. The pointer
+// is not moved, and the block uses the same offsets as the code around it.
+type If struct {
+	Offset int
+	Spans
+}
+
+func (i If) Instruction() string {
+	return fmt.Sprintf("If Not Zero at %d", i.Offset)
+}
+
+func (i If) MemOffset() int {
+	return i.Offset
+}
+
+// EndIf instruction signals the end of an if block. The cell at the given
+// offset, which was tested by the matching If, is always zero after it.
+type EndIf struct {
+	Offset int
+	Spans
+}
+
+func (e EndIf) Instruction() string {
+	return fmt.Sprintf("End If at %d", e.Offset)
+}
+
+func (e EndIf) MemOffset() int {
+	return e.Offset
+}
+
 // Set sets value of the cell at the given offset from the current cell to
 // the given value.
 type Set struct {
 	X      byte
 	Offset int
+	Spans
 }
 
 func (c Set) Instruction() string {
@@ -117,3 +186,38 @@ func (c Set) Instruction() string {
 func (c Set) MemOffset() int {
 	return c.Offset
 }
+
+// Mul instruction changes the value of the cell at the given offset from
+// the current cell by X times the value of the cell at the Source offset.
+// It is produced by the optimizer in place of multiplication loops, like
+// [->++<], which add multiples of a cell to other cells.
+type Mul struct {
+	X      byte
+	Source int
+	Offset int
+	Spans
+}
+
+func (m Mul) Instruction() string {
+	return fmt.Sprintf("Change Value at %d by %d times Value at %d", m.Offset, int8(m.X), m.Source)
+}
+
+func (m Mul) MemOffset() int {
+	return m.Offset
+}
+
+// Print instruction outputs the byte X as a string, i.e. 65 -> A. It is
+// produced by the optimizer in place of Output instructions when the value
+// of the cell being output is known at compile time.
+type Print struct {
+	X byte
+	Spans
+}
+
+func (p Print) Instruction() string {
+	return fmt.Sprintf("Print Byte %d", p.X)
+}
+
+func (p Print) MemOffset() int {
+	return 0
+}
@@ -0,0 +1,284 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instruction
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"laptudirm.com/x/brainfuck/pkg/token"
+)
+
+// JSONVersion is the version of the JSON schema of chunks. It is changed
+// whenever the schema changes in an incompatible way.
+const JSONVersion = 1
+
+// jsonChunk is the JSON form of a Chunk, see DecodeJSON.
+type jsonChunk struct {
+	Version      int               `json:"version"`
+	Instructions []jsonInstruction `json:"instructions"`
+}
+
+// jsonInstruction is the JSON form of an Instruction. Fields which are not
+// used by an instruction are nil.
+type jsonInstruction struct {
+	Kind     string       `json:"kind"`
+	X        *int         `json:"x,omitempty"`
+	Source   *int         `json:"source,omitempty"`
+	Offset   *int         `json:"offset,omitempty"`
+	Balanced *bool        `json:"balanced,omitempty"`
+	Spans    []token.Span `json:"spans,omitempty"`
+}
+
+// jsonField identifies a field of an instruction in it's JSON form.
+type jsonField int
+
+const (
+	fieldX jsonField = 1 << iota
+	fieldSource
+	fieldOffset
+	fieldBalanced
+)
+
+// jsonFields stores the fields used by each kind of instruction.
+var jsonFields = map[string]jsonField{
+	"Value":     fieldX | fieldOffset,
+	"Set":       fieldX | fieldOffset,
+	"Mul":       fieldX | fieldSource | fieldOffset,
+	"Input":     fieldOffset,
+	"Output":    fieldOffset,
+	"Print":     fieldX,
+	"StartLoop": fieldOffset | fieldBalanced,
+	"EndLoop":   fieldOffset | fieldBalanced,
+	"If":        fieldOffset,
+	"EndIf":     fieldOffset,
+}
+
+// MarshalJSON implements the json.Marshaler interface. The JSON form of a
+// chunk is versioned by JSONVersion, and is described in the documentation
+// of DecodeJSON.
+func (c *Chunk) MarshalJSON() ([]byte, error) {
+	j := jsonChunk{
+		Version:      JSONVersion,
+		Instructions: make([]jsonInstruction, len(c.ins)),
+	}
+
+	for n, i := range c.ins {
+		var err error
+		if j.Instructions[n], err = toJSON(i); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(j)
+}
+
+// toJSON converts the given instruction into it's JSON form.
+func toJSON(i Instruction) (jsonInstruction, error) {
+	j := jsonInstruction{Spans: i.SourceSpans()}
+
+	x := func(v byte) *int { n := int(v); return &n }
+	offset := func(v int) *int { return &v }
+	balanced := func(v bool) *bool { return &v }
+
+	switch v := i.(type) {
+	case Value:
+		j.Kind, j.X, j.Offset = "Value", x(v.X), offset(v.Offset)
+	case Set:
+		j.Kind, j.X, j.Offset = "Set", x(v.X), offset(v.Offset)
+	case Mul:
+		j.Kind, j.X, j.Source, j.Offset = "Mul", x(v.X), offset(v.Source), offset(v.Offset)
+	case Input:
+		j.Kind, j.Offset = "Input", offset(v.Offset)
+	case Output:
+		j.Kind, j.Offset = "Output", offset(v.Offset)
+	case Print:
+		j.Kind, j.X = "Print", x(v.X)
+	case StartLoop:
+		j.Kind, j.Offset, j.Balanced = "StartLoop", offset(v.Offset), balanced(v.Balanced)
+	case EndLoop:
+		j.Kind, j.Offset, j.Balanced = "EndLoop", offset(v.Offset), balanced(v.Balanced)
+	case If:
+		j.Kind, j.Offset = "If", offset(v.Offset)
+	case EndIf:
+		j.Kind, j.Offset = "EndIf", offset(v.Offset)
+	default:
+		return j, fmt.Errorf("instruction: can't encode instruction type %T", i)
+	}
+
+	return j, nil
+}
+
+// JSONError represents an error in the JSON form of a chunk.
+type JSONError struct {
+	Index   int   // index of the invalid instruction, or -1 for the chunk
+	message error // the error
+}
+
+// Error implements the error interface.
+func (e *JSONError) Error() string {
+	if e.Index < 0 {
+		return fmt.Sprintf("instruction: json: %v", e.message)
+	}
+
+	return fmt.Sprintf("instruction: json: instruction %d: %v", e.Index, e.message)
+}
+
+// Unwrap exposes the underlying error in JSONError.
+func (e *JSONError) Unwrap() error {
+	return e.message
+}
+
+// DecodeJSON decodes the JSON form of a chunk. Decoding is strict: the
+// chunk must be the only value in data, the version must be JSONVersion,
+// the instructions must be a list, unknown kinds and fields are rejected,
+// every field used by an instruction must be present, values must be in
+// range, and loops and ifs must be properly nested. Like ParseText, it returns a
+// *VerifyError if the chunk is not valid otherwise. The JSON form is:
+//
+//	{"version": 1, "instructions": [...]}
+//
+// Each instruction is an object with it's kind and all of it's fields:
+//
+//	{"kind": "Value", "x": 255, "offset": 0}
+//	{"kind": "Set", "x": 0, "offset": 0}
+//	{"kind": "Mul", "x": 3, "source": 0, "offset": 1}
+//	{"kind": "Input", "offset": 0}
+//	{"kind": "Output", "offset": 0}
+//	{"kind": "Print", "x": 72}
+//	{"kind": "StartLoop", "offset": 0, "balanced": false}
+//	{"kind": "EndLoop", "offset": 0, "balanced": false}
+//	{"kind": "If", "offset": 0}
+//	{"kind": "EndIf", "offset": 0}
+//
+// Values are numbers from 0 to 255. Any instruction may also have a list
+// of spans, which is omitted if it is empty:
+//
+//	"spans": [{"start": {"line": 1, "column": 2}, "end": {"line": 1, "column": 5}}]
+func DecodeJSON(data []byte) (*Chunk, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+
+	var j jsonChunk
+	if err := d.Decode(&j); err != nil {
+		return nil, &JSONError{-1, err}
+	}
+
+	if _, err := d.Token(); err != io.EOF {
+		return nil, &JSONError{-1, errors.New("unexpected data after chunk")}
+	}
+
+	if j.Version != JSONVersion {
+		return nil, &JSONError{-1, fmt.Errorf("unsupported version %d", j.Version)}
+	}
+
+	if j.Instructions == nil {
+		// missing or null
+		return nil, &JSONError{-1, errors.New("missing instructions")}
+	}
+
+	var n nesting
+	ins := make([]Instruction, len(j.Instructions))
+	for k, v := range j.Instructions {
+		i, err := v.instruction()
+		if err == nil {
+			err = n.next(i)
+		}
+
+		if err != nil {
+			return nil, &JSONError{k, err}
+		}
+
+		ins[k] = i
+	}
+
+	if err := n.end(); err != nil {
+		return nil, &JSONError{-1, err}
+	}
+
+	c := &Chunk{ins: ins}
+	if v := Verify(c); len(v) > 0 {
+		return nil, &VerifyError{v}
+	}
+
+	return c, nil
+}
+
+// instruction converts the JSON form of an instruction into the instruction,
+// checking that it is valid.
+func (j jsonInstruction) instruction() (Instruction, error) {
+	fields, ok := jsonFields[j.Kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown kind %q", j.Kind)
+	}
+
+	// check that exactly the fields used by the kind are present
+	for _, f := range []struct {
+		field   jsonField
+		name    string
+		present bool
+	}{
+		{fieldX, "x", j.X != nil},
+		{fieldSource, "source", j.Source != nil},
+		{fieldOffset, "offset", j.Offset != nil},
+		{fieldBalanced, "balanced", j.Balanced != nil},
+	} {
+		switch used := fields&f.field != 0; {
+		case used && !f.present:
+			return nil, fmt.Errorf("missing field %q for kind %s", f.name, j.Kind)
+		case !used && f.present:
+			return nil, fmt.Errorf("unexpected field %q for kind %s", f.name, j.Kind)
+		}
+	}
+
+	var x byte
+	if j.X != nil {
+		if *j.X < 0 || *j.X > 255 {
+			return nil, fmt.Errorf("value %d out of range", *j.X)
+		}
+
+		x = byte(*j.X)
+	}
+
+	var spans Spans
+	if len(j.Spans) > 0 {
+		spans = j.Spans
+	}
+
+	switch j.Kind {
+	case "Value":
+		return Value{X: x, Offset: *j.Offset, Spans: spans}, nil
+	case "Set":
+		return Set{X: x, Offset: *j.Offset, Spans: spans}, nil
+	case "Mul":
+		return Mul{X: x, Source: *j.Source, Offset: *j.Offset, Spans: spans}, nil
+	case "Input":
+		return Input{Offset: *j.Offset, Spans: spans}, nil
+	case "Output":
+		return Output{Offset: *j.Offset, Spans: spans}, nil
+	case "Print":
+		return Print{X: x, Spans: spans}, nil
+	case "StartLoop":
+		return StartLoop{Offset: *j.Offset, Balanced: *j.Balanced, Spans: spans}, nil
+	case "EndLoop":
+		return EndLoop{Offset: *j.Offset, Balanced: *j.Balanced, Spans: spans}, nil
+	case "If":
+		return If{Offset: *j.Offset, Spans: spans}, nil
+	default: // "EndIf"
+		return EndIf{Offset: *j.Offset, Spans: spans}, nil
+	}
+}
@@ -0,0 +1,132 @@
+package instruction_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+	"laptudirm.com/x/brainfuck/pkg/lexer"
+	"laptudirm.com/x/brainfuck/pkg/parser"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	for _, src := range textTests {
+		var c instruction.ChunkBuilder
+		chunk, err := parser.ParseWith(lexer.Lex([]byte(src)), &c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := instruction.DecodeJSON(data)
+		if err != nil {
+			t.Errorf("%q: %v\n%s", src, err, data)
+			continue
+		}
+
+		if ins := instructions(decoded); !reflect.DeepEqual(ins, instructions(chunk)) {
+			t.Errorf("%q: expected %v, received %v", src, instructions(chunk), ins)
+		}
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	chunk, err := instruction.ParseText([]byte(`
+Input@0 ; 1:1
+StartBalancedLoop@0
+	Mul(-1, 0)@1 ; 1:3-1:5
+	Print(72)
+EndBalancedLoop@0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(chunk)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"version":1,"instructions":[` +
+		`{"kind":"Input","offset":0,"spans":[{"start":{"line":1,"column":1},"end":{"line":1,"column":1}}]},` +
+		`{"kind":"StartLoop","offset":0,"balanced":true},` +
+		`{"kind":"Mul","x":255,"source":0,"offset":1,"spans":[{"start":{"line":1,"column":3},"end":{"line":1,"column":5}}]},` +
+		`{"kind":"Print","x":72},` +
+		`{"kind":"EndLoop","offset":0,"balanced":true}]}`
+
+	if string(data) != expected {
+		t.Errorf("expected %s, received %s", expected, data)
+	}
+}
+
+var jsonErrorTests = []struct {
+	src string
+	err string
+}{
+	{
+		src: `{"version":2,"instructions":[]}`,
+		err: "instruction: json: unsupported version 2",
+	},
+	{
+		src: `{"version":1,"instructions":[],"extra":0}`,
+		err: `instruction: json: json: unknown field "extra"`,
+	},
+	{
+		src: `{"version":1,"instructions":[]} garbage`,
+		err: "instruction: json: unexpected data after chunk",
+	},
+	{
+		src: `{"version":1,"instructions":[]}{}`,
+		err: "instruction: json: unexpected data after chunk",
+	},
+	{
+		src: `{"version":1,"instructions":null}`,
+		err: "instruction: json: missing instructions",
+	},
+	{
+		src: `{"version":1}`,
+		err: "instruction: json: missing instructions",
+	},
+	{
+		src: `{"version":1,"instructions":[{"kind":"Loop","offset":0}]}`,
+		err: `instruction: json: instruction 0: unknown kind "Loop"`,
+	},
+	{
+		src: `{"version":1,"instructions":[{"kind":"Value","x":1}]}`,
+		err: `instruction: json: instruction 0: missing field "offset" for kind Value`,
+	},
+	{
+		src: `{"version":1,"instructions":[{"kind":"If","offset":0,"balanced":true}]}`,
+		err: `instruction: json: instruction 0: unexpected field "balanced" for kind If`,
+	},
+	{
+		src: `{"version":1,"instructions":[{"kind":"Set","x":256,"offset":0}]}`,
+		err: "instruction: json: instruction 0: value 256 out of range",
+	},
+	{
+		src: `{"version":1,"instructions":[{"kind":"StartLoop","offset":0,"balanced":false},{"kind":"EndIf","offset":0}]}`,
+		err: "instruction: json: instruction 1: unexpected EndIf@0, StartLoop@0 not closed",
+	},
+	{
+		src: `{"version":1,"instructions":[{"kind":"If","offset":0}]}`,
+		err: "instruction: json: unexpected end of input, If@0 not closed",
+	},
+	{
+		src: `{"version":1,"instructions":[{"kind":"If","offset":0},{"kind":"EndIf","offset":1}]}`,
+		err: "instruction: invalid chunk, 1 violations:\n\t1: EndIf@1 has a different offset than If@0",
+	},
+}
+
+func TestDecodeJSONErrors(t *testing.T) {
+	for _, test := range jsonErrorTests {
+		_, err := instruction.DecodeJSON([]byte(test.src))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error %q, received %v", test.src, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,566 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instruction
+
+// foldKnownValues is an abstract interpretation pass which tracks the
+// values of cells which are known at compile time across straight-line
+// code. All cells are known to be zero at the start of the program, and
+// the tested cell is known to be zero after a loop exits.
+//
+// Loops and ifs whose tested cell is known to be zero are removed, ifs
+// and balanced loops which are known to be executed exactly once are
+// inlined, Value and Mul instructions on known cells are turned into Set
+// instructions, Set instructions which don't change the known value are
+// removed, and Output instructions of known cells are folded into Print
+// instructions.
+//
+// Balanced loops with a known trip count, i.e. whose control cell is known
+// and changed by a constant amount each iteration, are converted to closed
+// form if their bodies only change values, or unrolled if they are small.
+func foldKnownValues(ins []Instruction, r *remarks) []Instruction {
+	t := newTracker()
+
+	// shift is the offset of the current frame from the frame of the
+	// instructions being read, which can be non-zero after a loop is
+	// removed, since the loop would have moved the pointer.
+	shift := 0
+
+	// inlined stores the indices of the ends of inlined loops and ifs
+	inlined := make(map[int]bool)
+
+	dst := make([]Instruction, 0, len(ins))
+	for n := 0; n < len(ins); n++ {
+		switch v := ins[n].(type) {
+		case Value:
+			dst = foldValue(dst, t, v.Offset+shift, v.X, v.Spans)
+
+		case Set:
+			dst = foldSet(dst, t, v.Offset+shift, v.X, v.Spans)
+
+		case Mul:
+			v.Source += shift
+			v.Offset += shift
+
+			if x, ok := t.get(v.Source); ok {
+				// value of the source is known, so the change is constant,
+				// and the destination isn't touched if the source is zero
+				if x != 0 {
+					dst = foldValue(dst, t, v.Offset, v.X*x, v.Spans)
+				}
+				break
+			}
+
+			dst = append(dst, v)
+			t.forget(v.Offset)
+
+		case Input:
+			offset := v.Offset + shift
+			dst = append(dst, Input{Offset: offset, Spans: v.Spans})
+			t.forget(offset)
+
+		case Output:
+			offset := v.Offset + shift
+			if x, ok := t.get(offset); ok {
+				// value of the cell is known, so print it directly
+				dst = append(dst, Print{X: x, Spans: v.Spans})
+				break
+			}
+
+			dst = append(dst, Output{Offset: offset, Spans: v.Spans})
+
+		case Print:
+			dst = append(dst, v)
+
+		case StartLoop:
+			offset := v.Offset + shift
+			end := matchingEnd(ins, n)
+
+			if x, ok := t.get(offset); ok && x == 0 {
+				// the loop is never executed, so remove it, while keeping
+				// in mind that an unbalanced loop would have moved the pointer
+				r.remove(v.Spans, ins[end].(EndLoop).Spans, "removed: cell known zero")
+				n = end
+				if !v.Balanced {
+					shift = offset
+				}
+				break
+			}
+
+			body := ins[n+1 : end]
+			trips, ok := 0, false
+			if x, known := t.get(offset); known && v.Balanced {
+				trips, ok = tripCount(body, v.Offset, x)
+			}
+
+			if ok && trips == 1 {
+				// the body can be used as is
+				r.add(v.Pos(), RemarkOptimized, "inlined: body runs exactly once")
+				inlined[end] = true
+				break
+			}
+
+			if ok && onlyValues(body) {
+				// the changes made by all the iterations can be combined
+				r.add(v.Pos(), RemarkOptimized, "converted to closed form: body runs %d times", trips)
+				spans := spanning(v.Spans, ins[end].(EndLoop).Spans)
+				for _, i := range body {
+					if i.MemOffset() != v.Offset {
+						dst = foldValue(dst, t, i.MemOffset()+shift, i.(Value).X*byte(trips), spans)
+					}
+				}
+
+				dst = foldSet(dst, t, offset, 0, spans)
+				n = end
+				break
+			}
+
+			if ok && trips*len(body) <= maxUnrolled && !hasBranch(body) {
+				// replace the loop with copies of it's body and continue
+				// from the start of the first copy
+				r.add(v.Pos(), RemarkOptimized, "unrolled: body runs %d times", trips)
+				unrolled := make([]Instruction, 0, len(ins)+trips*len(body))
+				unrolled = append(unrolled, ins[:n]...)
+				for k := 0; k < trips; k++ {
+					unrolled = append(unrolled, body...)
+				}
+				unrolled = append(unrolled, ins[end+1:]...)
+
+				// the ends of the enclosing inlined loops have been moved
+				moved := make(map[int]bool, len(inlined))
+				for e := range inlined {
+					if e > end {
+						e += len(unrolled) - len(ins)
+					}
+
+					moved[e] = true
+				}
+
+				ins, inlined = unrolled, moved
+				n--
+				break
+			}
+
+			v.Offset = offset
+			dst = append(dst, v)
+
+			t.startLoop(v, ins[n+1:end], shift)
+			if !v.Balanced {
+				shift = 0
+			}
+
+		case EndLoop:
+			if inlined[n] {
+				break
+			}
+
+			v.Offset += shift
+			dst = append(dst, v)
+
+			t.endLoop(v)
+			if !v.Balanced {
+				shift = 0
+			}
+
+		case If:
+			offset := v.Offset + shift
+			end := matchingEnd(ins, n)
+
+			if x, ok := t.get(offset); ok {
+				if x == 0 {
+					// the body is never executed
+					r.remove(v.Spans, ins[end].(EndIf).Spans, "removed: cell known zero")
+					n = end
+				} else {
+					// the body is always executed
+					r.add(v.Pos(), RemarkOptimized, "inlined: cell known non-zero")
+					inlined[end] = true
+				}
+
+				break
+			}
+
+			v.Offset = offset
+			dst = append(dst, v)
+			t.startIf()
+
+		case EndIf:
+			if inlined[n] {
+				break
+			}
+
+			v.Offset += shift
+			dst = append(dst, v)
+			t.endIf(v)
+
+		default:
+			// unknown instruction, forget everything
+			dst = append(dst, v)
+			shift = 0
+			t.reset(false)
+		}
+	}
+
+	return dst
+}
+
+// maxUnrolled is the maximum number of instructions a loop is unrolled
+// into by foldKnownValues.
+const maxUnrolled = 64
+
+// foldValue appends the instruction from the given spans which changes the
+// value of the cell at the given offset by x to dst, if needed, and updates
+// the tracker.
+func foldValue(dst []Instruction, t *tracker, offset int, x byte, spans Spans) []Instruction {
+	if x == 0 {
+		// the value isn't changed
+		return dst
+	}
+
+	if v, ok := t.get(offset); ok {
+		// value of the cell is known, so just set the new value
+		t.set(offset, v+x)
+		return append(dst, Set{X: v + x, Offset: offset, Spans: spans})
+	}
+
+	return append(dst, Value{X: x, Offset: offset, Spans: spans})
+}
+
+// foldSet appends the instruction from the given spans which sets the value
+// of the cell at the given offset to x to dst, if needed, and updates the
+// tracker.
+func foldSet(dst []Instruction, t *tracker, offset int, x byte, spans Spans) []Instruction {
+	if v, ok := t.get(offset); ok && v == x {
+		// cell already has the value
+		return dst
+	}
+
+	t.set(offset, x)
+	return append(dst, Set{X: x, Offset: offset, Spans: spans})
+}
+
+// tripCount returns the number of times a balanced loop with the given
+// body is executed, if the value of it's control cell at the given offset
+// is x at the start. It returns false if the body doesn't change the cell
+// by a constant amount, or if the loop never terminates.
+func tripCount(body []Instruction, offset int, x byte) (int, bool) {
+	delta, ok := cellDelta(body, offset)
+	if !ok {
+		return 0, false
+	}
+
+	// the cell has the same value after 256 iterations
+	for n := 0; n < 256; n++ {
+		if x == 0 {
+			return n, true
+		}
+
+		x += delta
+	}
+
+	return 0, false
+}
+
+// onlyValues checks if the given instructions are all Value instructions.
+func onlyValues(ins []Instruction) bool {
+	for _, i := range ins {
+		if _, ok := i.(Value); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hasBranch checks if the given instructions contain a loop or an if.
+func hasBranch(ins []Instruction) bool {
+	for _, i := range ins {
+		switch i.(type) {
+		case StartLoop, If:
+			return true
+		}
+	}
+
+	return false
+}
+
+// tracker tracks what is known about the values of cells while walking
+// over a list of instructions, starting from the start of the program.
+type tracker struct {
+	knowledge
+
+	// saved stores the knowledge at the start of each enclosing balanced
+	// loop or if, which is still valid after it exits
+	saved []knowledge
+}
+
+// newTracker returns a tracker for the start of the program, where all
+// cells are known to be zero.
+func newTracker() *tracker {
+	var t tracker
+	t.reset(true)
+	return &t
+}
+
+// step updates the knowledge to after the nth instruction in the given
+// instructions, which are assumed to be valid.
+func (t *tracker) step(ins []Instruction, n int) {
+	switch v := ins[n].(type) {
+	case Value:
+		if x, ok := t.get(v.Offset); ok {
+			t.set(v.Offset, x+v.X)
+		}
+	case Set:
+		t.set(v.Offset, v.X)
+	case Mul:
+		x, ok := t.get(v.Source)
+		y, ok2 := t.get(v.Offset)
+
+		switch {
+		case ok && x == 0:
+			// nothing is changed
+		case ok && ok2:
+			t.set(v.Offset, y+v.X*x)
+		default:
+			t.forget(v.Offset)
+		}
+	case Input:
+		t.forget(v.Offset)
+	case Output, Print:
+		// doesn't write to any cell
+	case StartLoop:
+		t.startLoop(v, ins[n+1:matchingEnd(ins, n)], 0)
+	case EndLoop:
+		t.endLoop(v)
+	case If:
+		t.startIf()
+	case EndIf:
+		t.endIf(v)
+	default:
+		// unknown instruction, forget everything
+		t.reset(false)
+	}
+}
+
+// startLoop updates the knowledge to the start of the body of the given
+// loop. The offsets in the body are shifted by shift in the current frame.
+func (t *tracker) startLoop(s StartLoop, body []Instruction, shift int) {
+	if !s.Balanced {
+		// nothing is known at the start of the loop body, since it
+		// may have been executed any number of times
+		t.reset(false)
+		return
+	}
+
+	// the body of a balanced loop uses the same frame, so cells
+	// which are never written inside it keep their values
+	written, ok := writtenCells(body)
+	if !ok {
+		t.reset(false)
+	}
+
+	for _, o := range written {
+		t.forget(o + shift)
+	}
+
+	t.saved = append(t.saved, t.copy())
+}
+
+// endLoop updates the knowledge to after the given EndLoop.
+func (t *tracker) endLoop(e EndLoop) {
+	if !e.Balanced {
+		// the loop only exits when the tested cell is zero, and the
+		// pointer is at the tested cell after the loop
+		t.reset(false)
+		t.set(0, 0)
+		return
+	}
+
+	// restore the knowledge from the start of the loop
+	t.knowledge = t.pop()
+	t.set(e.Offset, 0)
+}
+
+// startIf updates the knowledge to the start of the body of an if.
+func (t *tracker) startIf() {
+	// the body is executed at most once, so everything known before it
+	// is also known at it's start
+	t.saved = append(t.saved, t.copy())
+}
+
+// endIf updates the knowledge to after the given EndIf.
+func (t *tracker) endIf(e EndIf) {
+	// only the things which are known whether or not the body is
+	// executed are known after the if
+	t.knowledge = meet(t.knowledge, t.pop())
+	t.set(e.Offset, 0)
+}
+
+// pop removes and returns the last saved knowledge.
+func (t *tracker) pop() knowledge {
+	k := t.saved[len(t.saved)-1]
+	t.saved = t.saved[:len(t.saved)-1]
+	return k
+}
+
+// matchingEnd returns the index of the EndLoop or EndIf instruction which
+// matches the StartLoop or If instruction at the given index.
+func matchingEnd(ins []Instruction, start int) int {
+	depth := 0
+	for n := start; n < len(ins); n++ {
+		switch ins[n].(type) {
+		case StartLoop, If:
+			depth++
+		case EndLoop, EndIf:
+			if depth--; depth == 0 {
+				return n
+			}
+		}
+	}
+
+	// unreachable for valid instructions
+	panic("instruction: unpaired StartLoop or If instruction")
+}
+
+// cellDelta returns the total change made to the cell at the given offset
+// by the given balanced loop body. If the change is not constant, i.e.
+// the cell is set, input to, or changed conditionally, it returns false.
+func cellDelta(body []Instruction, offset int) (byte, bool) {
+	var delta byte
+	depth := 0
+
+	for _, i := range body {
+		switch v := i.(type) {
+		case StartLoop, If:
+			depth++
+		case EndLoop, EndIf:
+			depth--
+		case Value:
+			if v.Offset != offset {
+				break
+			}
+
+			if depth > 0 {
+				return 0, false
+			}
+
+			delta += v.X
+		case Set, Input, Mul:
+			if v.MemOffset() == offset {
+				return 0, false
+			}
+		case Output, Print:
+			// doesn't write to any cell
+		default:
+			return 0, false
+		}
+	}
+
+	return delta, true
+}
+
+// writtenCells returns the offsets of the cells which may be written by the
+// given instructions, assuming that they don't move the pointer. If any of
+// the instructions is unknown, it returns false.
+func writtenCells(ins []Instruction) ([]int, bool) {
+	var offsets []int
+	for _, i := range ins {
+		switch v := i.(type) {
+		case Value, Set, Input, Mul:
+			offsets = append(offsets, v.MemOffset())
+		case Output, Print, StartLoop, EndLoop, If, EndIf:
+			// doesn't write to any cell
+		default:
+			return nil, false
+		}
+	}
+
+	return offsets, true
+}
+
+// knowledge represents what is known about the values of the cells of the
+// tape, relative to some frame.
+type knowledge struct {
+	cells map[int]cell // cells whose state differs from the default
+	zero  bool         // whether cells not in cells are known to be zero
+}
+
+// cell represents what is known about the value of a single cell.
+type cell struct {
+	known bool // whether the value is known
+	x     byte // value of the cell, if known
+}
+
+// get returns the value of the cell at the given offset and whether it is
+// known.
+func (k *knowledge) get(offset int) (byte, bool) {
+	if c, ok := k.cells[offset]; ok {
+		return c.x, c.known
+	}
+
+	return 0, k.zero
+}
+
+// set records that the cell at the given offset has the value x.
+func (k *knowledge) set(offset int, x byte) {
+	k.cells[offset] = cell{known: true, x: x}
+}
+
+// forget records that the value of the cell at the given offset is
+// unknown.
+func (k *knowledge) forget(offset int) {
+	if k.zero {
+		k.cells[offset] = cell{}
+		return
+	}
+
+	delete(k.cells, offset)
+}
+
+// reset forgets everything which is known. If zero is true, all cells are
+// known to be zero, otherwise nothing is known.
+func (k *knowledge) reset(zero bool) {
+	k.cells = make(map[int]cell)
+	k.zero = zero
+}
+
+// copy returns a copy of the knowledge which can be modified independently.
+func (k *knowledge) copy() knowledge {
+	cells := make(map[int]cell, len(k.cells))
+	for o, c := range k.cells {
+		cells[o] = c
+	}
+
+	return knowledge{cells: cells, zero: k.zero}
+}
+
+// meet returns the knowledge which is common to both a and b.
+func meet(a, b knowledge) knowledge {
+	k := knowledge{cells: make(map[int]cell), zero: a.zero && b.zero}
+
+	// cells which differ from the default in either a or b
+	for _, cells := range []map[int]cell{a.cells, b.cells} {
+		for o := range cells {
+			x, ok := a.get(o)
+			y, ok2 := b.get(o)
+
+			if ok && ok2 && x == y {
+				k.cells[o] = cell{known: true, x: x}
+			} else {
+				k.cells[o] = cell{}
+			}
+		}
+	}
+
+	return k
+}
@@ -0,0 +1,189 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instruction
+
+// Range represents the range of cells which may be accessed by some code,
+// as offsets from the position of the pointer before it.
+type Range struct {
+	Min int // lowest offset accessed
+	Max int // highest offset accessed
+
+	// Left and Right report whether the accesses may extend without limit
+	// below Min or above Max, which happens when loops move the pointer.
+	Left  bool
+	Right bool
+}
+
+// Bounded checks if the range is exactly known at compile time.
+func (r Range) Bounded() bool {
+	return !r.Left && !r.Right
+}
+
+// union returns the smallest range which contains both r and s.
+func (r Range) union(s Range) Range {
+	if s.Min < r.Min {
+		r.Min = s.Min
+	}
+
+	if s.Max > r.Max {
+		r.Max = s.Max
+	}
+
+	r.Left = r.Left || s.Left
+	r.Right = r.Right || s.Right
+	return r
+}
+
+// shift returns the range r when the pointer is somewhere in the range s.
+func (r Range) shift(s Range) Range {
+	return Range{
+		Min:   r.Min + s.Min,
+		Max:   r.Max + s.Max,
+		Left:  r.Left || s.Left,
+		Right: r.Right || s.Right,
+	}
+}
+
+// point returns the range which only contains the given offset.
+func point(offset int) Range {
+	return Range{Min: offset, Max: offset}
+}
+
+// Ranges returns the range of cells accessed by each instruction in the
+// given chunk, as offsets from the position of the pointer before that
+// instruction. The range of a StartLoop or If instruction covers the whole
+// loop or if, including all the iterations of it's body. Instructions
+// which don't access any cells, like Print, have the zero Range.
+//
+// Ranges of straight-line code, balanced loops and ifs are exact. The
+// ranges of loops which move the pointer are unbounded in the direction
+// of the movement.
+//
+// The range of a loop covers the cells it may access, not the ones it
+// must access, so it can't be used to report out of bounds accesses at
+// the entry of a loop. A target may only skip the checks inside a loop
+// whose range is within the tape, and must check every access of the
+// others, as the opcode VM and the Go target do.
+func Ranges(c *Chunk) []Range {
+	ranges := make([]Range, len(c.ins))
+	blockRange(c.ins, ranges)
+	return ranges
+}
+
+// Footprint returns the range of cells accessed by the given chunk, as
+// offsets from the initial position of the pointer.
+func Footprint(c *Chunk) Range {
+	accessed, _, _ := blockRange(c.ins, make([]Range, len(c.ins)))
+	return accessed
+}
+
+// blockRange finds the ranges of the given instructions, which must not
+// have unpaired loops or ifs, and stores them in ranges. It returns the
+// range of cells accessed by the instructions, whether any cells were
+// accessed, and the range of the position of the pointer after them,
+// all relative to the position of the pointer before them.
+func blockRange(ins []Instruction, ranges []Range) (Range, bool, Range) {
+	var accessed Range
+	found := false
+
+	frame := point(0)
+	for n := 0; n < len(ins); n++ {
+		var r Range
+		start, next := n, frame
+
+		switch v := ins[n].(type) {
+		case StartLoop, If:
+			end := matchingEnd(ins, n)
+
+			var exit Range
+			r, exit = loopRange(ins[n:end+1], ranges[n:end+1])
+			n, next = end, exit.shift(frame)
+
+		case Value, Set, Input, Output:
+			r = point(v.MemOffset())
+
+		case Mul:
+			r = point(v.Source).union(point(v.Offset))
+
+		case Print:
+			// doesn't access any cell
+			continue
+
+		default:
+			// unknown instruction, may do anything
+			r = Range{Left: true, Right: true}
+		}
+
+		ranges[start] = r
+
+		if r = r.shift(frame); found {
+			accessed = accessed.union(r)
+		} else {
+			accessed, found = r, true
+		}
+
+		frame = next
+	}
+
+	return accessed, found, frame
+}
+
+// loopRange finds the ranges of the given loop or if, including the
+// StartLoop or If and the matching EndLoop or EndIf instructions, and
+// stores them in ranges. It returns the range of cells accessed by it, and
+// the range of the position of the pointer after it.
+func loopRange(ins []Instruction, ranges []Range) (Range, Range) {
+	last := len(ins) - 1
+	accessed, accessedBody, end := blockRange(ins[1:last], ranges[1:last])
+
+	switch v := ins[0].(type) {
+	case If:
+		ranges[last] = point(v.Offset)
+	case StartLoop:
+		ranges[last] = point(v.Offset)
+		if v.Balanced {
+			break
+		}
+
+		// the loop is entered after moving the pointer by the offset of
+		// the StartLoop, and each iteration moves it by the offset of the
+		// EndLoop after the movement of the body
+		e := ins[last].(EndLoop).Offset
+		ranges[last] = point(e)
+
+		// the cells tested at the start and end of an iteration
+		step := point(e).shift(end)
+		iteration := point(0).union(step)
+		if accessedBody {
+			iteration = iteration.union(accessed)
+		}
+
+		// the pointer may move in the directions of the movement of an
+		// iteration any number of times
+		left := step.Min < 0 || step.Left
+		right := step.Max > 0 || step.Right
+
+		exit := Range{Min: v.Offset, Max: v.Offset, Left: left, Right: right}
+		return iteration.shift(exit), exit
+	}
+
+	// balanced loops and ifs use the same frame as the code around them
+	// and don't move the pointer
+	r := point(ins[0].MemOffset())
+	if accessedBody {
+		r = r.union(accessed)
+	}
+
+	return r, point(0)
+}
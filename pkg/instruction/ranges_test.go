@@ -0,0 +1,44 @@
+package instruction_test
+
+import (
+	"reflect"
+	"testing"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+)
+
+var footprintTests = []struct {
+	src       string
+	footprint instruction.Range
+}{
+	{src: ",>,>>,", footprint: instruction.Range{Min: 0, Max: 3}},
+	{src: ",[<<+>>-]", footprint: instruction.Range{Min: -2, Max: 0}},
+	{src: ",[>>,.<<,]", footprint: instruction.Range{Min: 0, Max: 2}},
+	{src: ",[>]", footprint: instruction.Range{Min: 0, Max: 1, Right: true}},
+	{src: ",[<]", footprint: instruction.Range{Min: -1, Max: 0, Left: true}},
+	{src: ">,[<]>>.", footprint: instruction.Range{Min: 0, Max: 3, Left: true}},
+	{src: ",[>,[<]>]", footprint: instruction.Range{Min: 0, Max: 2, Left: true, Right: true}},
+}
+
+func TestFootprint(t *testing.T) {
+	for _, test := range footprintTests {
+		footprint := instruction.Footprint(build(test.src))
+		if footprint != test.footprint {
+			t.Errorf("%s: expected %+v, received %+v", test.src, test.footprint, footprint)
+		}
+	}
+}
+
+func TestRanges(t *testing.T) {
+	expected := []instruction.Range{
+		{Min: 1, Max: 1},
+		{Min: 0, Max: 1, Left: true},
+		{Min: -1, Max: -1},
+		{Min: 2, Max: 2},
+	}
+
+	ranges := instruction.Ranges(build(">,[<]>>."))
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("expected %+v, received %+v", expected, ranges)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instruction
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"laptudirm.com/x/brainfuck/pkg/token"
+)
+
+// Remark explains an optimization which was performed on a loop, or why
+// a loop could not be optimized.
+type Remark struct {
+	Pos     token.Position `json:"pos"`     // position of the loop
+	Kind    RemarkKind     `json:"kind"`    // kind of remark
+	Message string         `json:"message"` // human readable explanation
+}
+
+// String converts a Remark into a human readable string.
+func (r Remark) String() string {
+	return fmt.Sprintf("loop at %s %s", r.Pos, r.Message)
+}
+
+// RemarkKind represents the kind of a Remark.
+type RemarkKind string
+
+// Various kinds of remarks.
+const (
+	RemarkOptimized RemarkKind = "optimized" // loop was transformed
+	RemarkRemoved   RemarkKind = "removed"   // loop was removed
+	RemarkMissed    RemarkKind = "missed"    // loop could not be optimized
+)
+
+// remarks collects the remarks made while building a chunk.
+type remarks []Remark
+
+// add adds a new remark about the loop at the given position. Every loop
+// has a single remark which states the final outcome, so it replaces any
+// earlier remark about the loop. Loops without a position can't be told
+// apart, so only identical remarks about them are replaced.
+func (r *remarks) add(pos token.Position, kind RemarkKind, format string, a ...interface{}) {
+	remark := Remark{
+		Pos:     pos,
+		Kind:    kind,
+		Message: fmt.Sprintf(format, a...),
+	}
+
+	for n, old := range *r {
+		if old.Pos == pos && (pos != token.Position{} || old == remark) {
+			(*r)[n] = remark
+			return
+		}
+	}
+
+	*r = append(*r, remark)
+}
+
+// remove adds a remark about the removal of the loop whose start and end
+// instructions have the given spans. The remarks about the loops inside it
+// are dropped, since they were removed too.
+func (r *remarks) remove(start, end Spans, format string, a ...interface{}) {
+	pos := start.Pos()
+	if pos != (token.Position{}) && len(end) > 0 {
+		last := end[len(end)-1].End
+
+		kept := (*r)[:0]
+		for _, remark := range *r {
+			if pos.Before(remark.Pos) && !last.Before(remark.Pos) {
+				// loop inside the removed loop
+				continue
+			}
+
+			kept = append(kept, remark)
+		}
+
+		*r = kept
+	}
+
+	r.add(pos, RemarkRemoved, format, a...)
+}
+
+// sorted returns a copy of the remarks sorted by their positions.
+func (r remarks) sorted() []Remark {
+	s := make([]Remark, len(r))
+	copy(s, r)
+
+	sort.SliceStable(s, func(i, j int) bool {
+		if s[i].Pos.Line != s[j].Pos.Line {
+			return s[i].Pos.Line < s[j].Pos.Line
+		}
+
+		return s[i].Pos.Column < s[j].Pos.Column
+	})
+
+	return s
+}
+
+// describe returns a short description of the given instructions for use
+// in remarks.
+func describe(ins []Instruction) string {
+	if len(ins) == 0 {
+		return "nothing"
+	}
+
+	s := make([]string, len(ins))
+	for n, i := range ins {
+		s[n] = i.Instruction()
+	}
+
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,124 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instruction
+
+import (
+	"sort"
+
+	"laptudirm.com/x/brainfuck/pkg/token"
+)
+
+// Spans is the list of spans of source code an instruction was derived
+// from, sorted by their positions. It is embedded in every instruction.
+// Instructions built without source positions have no spans.
+type Spans []token.Span
+
+// SourceSpans returns the spans of source code the instruction was
+// derived from.
+func (s Spans) SourceSpans() []token.Span {
+	return s
+}
+
+// Pos returns the start of the first span, or the zero Position if there
+// are no spans.
+func (s Spans) Pos() token.Position {
+	if len(s) == 0 {
+		return token.Position{}
+	}
+
+	return s[0].Start
+}
+
+// merge returns the spans in both s and t, joining spans which overlap or
+// are next to each other on the same line.
+func (s Spans) merge(t Spans) Spans {
+	if len(s)+len(t) == 0 {
+		return nil
+	}
+
+	// always copy, since the spans may be shared with other instructions
+	all := make(Spans, 0, len(s)+len(t))
+	all = append(append(all, s...), t...)
+
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Start.Before(all[j].Start)
+	})
+
+	merged := all[:1]
+	for _, span := range all[1:] {
+		last := &merged[len(merged)-1]
+
+		// the position right after the end of the last span
+		next := last.End
+		next.Column++
+
+		switch {
+		case next.Before(span.Start):
+			merged = append(merged, span)
+		case last.End.Before(span.End):
+			last.End = span.End
+		}
+	}
+
+	return merged
+}
+
+// spanning returns the spans of a loop whose start and end instructions
+// have the given spans, which cover the whole loop.
+func spanning(start, end Spans) Spans {
+	if len(start) == 0 || len(end) == 0 {
+		return start.merge(end)
+	}
+
+	return Spans{{Start: start[0].Start, End: end[len(end)-1].End}}
+}
+
+// withSpans returns the given instruction with it's spans replaced by s.
+func withSpans(i Instruction, s Spans) Instruction {
+	switch v := i.(type) {
+	case Value:
+		v.Spans = s
+		return v
+	case Set:
+		v.Spans = s
+		return v
+	case Mul:
+		v.Spans = s
+		return v
+	case Input:
+		v.Spans = s
+		return v
+	case Output:
+		v.Spans = s
+		return v
+	case Print:
+		v.Spans = s
+		return v
+	case StartLoop:
+		v.Spans = s
+		return v
+	case EndLoop:
+		v.Spans = s
+		return v
+	case If:
+		v.Spans = s
+		return v
+	case EndIf:
+		v.Spans = s
+		return v
+	default:
+		// unknown instruction
+		return i
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instruction
+
+import "fmt"
+
+// Counts stores the number of instructions of each kind, keyed by the name
+// of their type, like "Value".
+type Counts map[string]int
+
+// Stats represents static metrics of a program.
+type Stats struct {
+	// Before and After are the instructions of the program before and
+	// after optimization. Before is nil if it is unknown. Pointer movement
+	// isn't an instruction, so it isn't counted.
+	Before Counts
+	After  Counts
+
+	// MaxDepth is the maximum nesting depth of loops and ifs after
+	// optimization, which is 0 if there are none.
+	MaxDepth int
+
+	// Loops is the number of loops before optimization, of which
+	// OptimizedLoops were removed or converted into other instructions or
+	// ifs, while RemainingLoops are still loops.
+	Loops          int
+	OptimizedLoops int
+	RemainingLoops int
+
+	Tape       Range // range of cells accessed, see Footprint
+	ReadsInput bool  // whether the optimized program reads any input
+}
+
+// Measure returns the statistics of the given chunk. Since the chunk is
+// already optimized, the statistics before optimization are unknown, and
+// all of it's loops are counted as remaining.
+func Measure(c *Chunk) Stats {
+	s := Stats{
+		After: make(Counts),
+		Tape:  Footprint(c),
+	}
+
+	depth := 0
+	for _, i := range c.ins {
+		s.After[kind(i)]++
+
+		switch i.(type) {
+		case StartLoop, If:
+			if depth++; depth > s.MaxDepth {
+				s.MaxDepth = depth
+			}
+		case EndLoop, EndIf:
+			depth--
+		}
+	}
+
+	s.Loops = s.After["StartLoop"]
+	s.RemainingLoops = s.Loops
+	s.ReadsInput = s.After["Input"] > 0
+	return s
+}
+
+// Stats returns the statistics of the program built by the builder, which
+// must have been finalized.
+func (c *ChunkBuilder) Stats() Stats {
+	if !c.finalized {
+		panic("chunk builder: can't get stats of chunk which is not finalized")
+	}
+
+	s := Measure(&Chunk{ins: c.ins})
+
+	s.Before = make(Counts, len(c.before))
+	for k, n := range c.before {
+		s.Before[k] = n
+	}
+
+	s.Loops = s.Before["StartLoop"]
+	s.OptimizedLoops = s.Loops - s.RemainingLoops
+	return s
+}
+
+// kind returns the name of the type of the given instruction.
+func kind(i Instruction) string {
+	switch i.(type) {
+	case Value:
+		return "Value"
+	case Set:
+		return "Set"
+	case Mul:
+		return "Mul"
+	case Input:
+		return "Input"
+	case Output:
+		return "Output"
+	case Print:
+		return "Print"
+	case StartLoop:
+		return "StartLoop"
+	case EndLoop:
+		return "EndLoop"
+	case If:
+		return "If"
+	case EndIf:
+		return "EndIf"
+	default:
+		// unknown instruction
+		return fmt.Sprintf("%T", i)
+	}
+}
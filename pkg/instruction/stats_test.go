@@ -0,0 +1,57 @@
+package instruction_test
+
+import (
+	"reflect"
+	"testing"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+)
+
+func TestStats(t *testing.T) {
+	c := instruction.ChunkBuilder{KeepTrailing: true}
+	buildWith(&c, ",[>+<-]>[[-<.>]>]")
+
+	expected := instruction.Stats{
+		Before: instruction.Counts{"Value": 3, "Input": 1, "Output": 1, "StartLoop": 3, "EndLoop": 3},
+		After: instruction.Counts{
+			"Input": 1, "Mul": 1, "Set": 1, "StartLoop": 2, "EndLoop": 2,
+			"Output": 1, "Value": 1,
+		},
+		MaxDepth:       2,
+		Loops:          3,
+		OptimizedLoops: 1,
+		RemainingLoops: 2,
+		Tape:           instruction.Range{Min: 0, Max: 2, Right: true},
+		ReadsInput:     true,
+	}
+
+	if s := c.Stats(); !reflect.DeepEqual(s, expected) {
+		t.Errorf("expected %+v, received %+v", expected, s)
+	}
+}
+
+func TestMeasure(t *testing.T) {
+	chunk, err := instruction.ParseText([]byte(`
+Print(72)
+StartLoop@2
+	If@0
+		Set(0)@0
+	EndIf@0
+EndLoop@-1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := instruction.Stats{
+		After:          instruction.Counts{"Print": 1, "StartLoop": 1, "EndLoop": 1, "If": 1, "Set": 1, "EndIf": 1},
+		MaxDepth:       2,
+		Loops:          1,
+		RemainingLoops: 1,
+		Tape:           instruction.Range{Min: 1, Max: 2, Left: true},
+	}
+
+	if s := instruction.Measure(chunk); !reflect.DeepEqual(s, expected) {
+		t.Errorf("expected %+v, received %+v", expected, s)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instruction
+
+import (
+	"fmt"
+
+	"laptudirm.com/x/brainfuck/pkg/token"
+)
+
+// Warning represents a possible problem in a program which was found by
+// static analysis.
+type Warning struct {
+	Pos     token.Position `json:"pos"`     // position of the problem
+	Message string         `json:"message"` // human readable explanation
+}
+
+// String converts a Warning into a human readable string.
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: warning: %s", w.Pos, w.Message)
+}
+
+// CheckTermination looks for balanced loops in the given chunk which
+// provably never terminate, using what is known about the values of the
+// cells when the loops are entered. It reports a loop if:
+//
+// - the loop's body never changes it's control cell, which is known to be
+// non-zero, or unknown, in which case the loop never terminates only if
+// it is entered, e.g. +[] or [.].
+//
+// - the loop's body only changes it's control cell by amounts which can
+// never change it's known value to zero, e.g. by even amounts when the
+// value is odd.
+func CheckTermination(c *Chunk) []Warning {
+	var warnings []Warning
+
+	t := newTracker()
+	for n := range c.ins {
+		if s, ok := c.ins[n].(StartLoop); ok && s.Balanced {
+			body := c.ins[n+1 : matchingEnd(c.ins, n)]
+			x, known := t.get(s.Offset)
+
+			if msg, ok := neverTerminates(body, s.Offset, x, known); ok {
+				warnings = append(warnings, Warning{
+					Pos:     s.Pos(),
+					Message: "loop never terminates" + msg,
+				})
+			}
+		}
+
+		t.step(c.ins, n)
+	}
+
+	return warnings
+}
+
+// neverTerminates checks if a balanced loop with the given body and control
+// cell never terminates. x is the value of the control cell at the start of
+// the loop, if known. If it never terminates, it returns an explanation.
+func neverTerminates(body []Instruction, offset int, x byte, known bool) (string, bool) {
+	if known && x == 0 {
+		// loop is never executed
+		return "", false
+	}
+
+	// the changes made to the control cell by the body
+	var changes []byte
+
+	for _, i := range body {
+		switch v := i.(type) {
+		case Value:
+			if v.Offset == offset {
+				changes = append(changes, v.X)
+			}
+		case Set, Input, Mul:
+			if v.MemOffset() == offset {
+				// the value of the cell can't be predicted
+				return "", false
+			}
+		case Output, Print, StartLoop, EndLoop, If, EndIf:
+			// doesn't write to any cell
+		default:
+			return "", false
+		}
+	}
+
+	switch {
+	case len(changes) == 0 && known:
+		return fmt.Sprintf(": control cell is %d and never changed", x), true
+	case len(changes) == 0:
+		return " if entered: control cell is never changed", true
+	case !known:
+		return "", false
+	}
+
+	// x can never become zero if all the changes are multiples of a
+	// power of 2 which doesn't divide x, since the cell wraps at 256
+	step := lowestBit(x) << 1
+	if step == 0 {
+		// x is 128, which any non-zero change can make zero
+		return "", false
+	}
+
+	for _, change := range changes {
+		if change%step != 0 {
+			return "", false
+		}
+	}
+
+	return fmt.Sprintf(": control cell is %d and only changed by multiples of %d", x, step), true
+}
+
+// lowestBit returns the lowest set bit in the given non-zero byte.
+func lowestBit(x byte) byte {
+	return x & -x
+}
@@ -0,0 +1,35 @@
+package instruction_test
+
+import (
+	"testing"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+)
+
+var terminationTests = []struct {
+	src      string
+	warnings int
+}{
+	{src: "+[]", warnings: 1},
+	{src: "+[--]", warnings: 1},
+	{src: "+[++]", warnings: 1},
+	{src: "++[--]", warnings: 0},
+	{src: ",[--]", warnings: 0},
+	{src: ",[.]", warnings: 1},
+	{src: ",[.-]", warnings: 0},
+	{src: "+++[>+<--]", warnings: 1},
+	{src: "++[>+<--]", warnings: 0},
+	{src: "++[>+<----]", warnings: 1},
+	{src: "++[>+<-]", warnings: 0},
+	{src: ",[>+<--]", warnings: 0},
+	{src: "+[>+<--[-]]", warnings: 0},
+}
+
+func TestCheckTermination(t *testing.T) {
+	for _, test := range terminationTests {
+		warnings := instruction.CheckTermination(build(test.src))
+		if len(warnings) != test.warnings {
+			t.Errorf("%s: expected %d warnings, received %v", test.src, test.warnings, warnings)
+		}
+	}
+}
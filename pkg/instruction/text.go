@@ -0,0 +1,374 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instruction
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+
+	"laptudirm.com/x/brainfuck/pkg/internal/scan"
+	"laptudirm.com/x/brainfuck/pkg/token"
+)
+
+// FormatText returns the textual form of the given chunk, which can be
+// read back by ParseText. The textual form has an instruction on each
+// line, which is written as it's name followed by it's arguments and it's
+// offset after an @, like the instructions of rewrite rules:
+//
+//	Value(x)@offset         change the cell by x
+//	Set(x)@offset           set the cell to x
+//	Mul(x, source)@offset   change the cell by x times the source cell
+//	Input@offset            input a byte into the cell
+//	Output@offset           output the cell
+//	Print(x)                output the byte x
+//	StartLoop@offset        start of a loop, see StartLoop
+//	EndLoop@offset          end of a loop, see EndLoop
+//	StartBalancedLoop@offset
+//	EndBalancedLoop@offset  start and end of a balanced loop
+//	If@offset               start of an if, see If
+//	EndIf@offset            end of an if
+//
+// Values are numbers from -128 to 255, which wrap like cell values. The
+// spans of an instruction are written after a ; as a comma separated list
+// of positions or ranges of positions, like 1:2 or 1:2-3:4. Indentation
+// and empty lines are ignored, and comments start with a # and continue
+// till the end of the line. For example:
+//
+//	Input@0 ; 1:1
+//	StartBalancedLoop@0 ; 1:2
+//		Output@0 ; 1:3
+//		Value(-1)@0 ; 1:4-1:5
+//	EndBalancedLoop@0 ; 1:6
+//
+// Loop and if bodies are indented by a tab.
+func FormatText(c *Chunk) []byte {
+	var b bytes.Buffer
+
+	depth := 0
+	for _, i := range c.ins {
+		switch i.(type) {
+		case EndLoop, EndIf:
+			depth--
+		}
+
+		b.WriteString(strings.Repeat("\t", depth))
+		b.WriteString(formatInstruction(i))
+		if spans := i.SourceSpans(); len(spans) > 0 {
+			b.WriteString(" ; ")
+			for n, s := range spans {
+				if n > 0 {
+					b.WriteString(", ")
+				}
+
+				b.WriteString(s.String())
+			}
+		}
+
+		b.WriteByte('\n')
+
+		switch i.(type) {
+		case StartLoop, If:
+			depth++
+		}
+	}
+
+	return b.Bytes()
+}
+
+// formatInstruction returns the textual form of the given instruction,
+// without it's spans.
+func formatInstruction(i Instruction) string {
+	switch v := i.(type) {
+	case Value:
+		return fmt.Sprintf("Value(%d)@%d", int8(v.X), v.Offset)
+	case Set:
+		return fmt.Sprintf("Set(%d)@%d", v.X, v.Offset)
+	case Mul:
+		return fmt.Sprintf("Mul(%d, %d)@%d", int8(v.X), v.Source, v.Offset)
+	case Input:
+		return fmt.Sprintf("Input@%d", v.Offset)
+	case Output:
+		return fmt.Sprintf("Output@%d", v.Offset)
+	case Print:
+		return fmt.Sprintf("Print(%d)", v.X)
+	case StartLoop:
+		if v.Balanced {
+			return fmt.Sprintf("StartBalancedLoop@%d", v.Offset)
+		}
+
+		return fmt.Sprintf("StartLoop@%d", v.Offset)
+	case EndLoop:
+		if v.Balanced {
+			return fmt.Sprintf("EndBalancedLoop@%d", v.Offset)
+		}
+
+		return fmt.Sprintf("EndLoop@%d", v.Offset)
+	case If:
+		return fmt.Sprintf("If@%d", v.Offset)
+	case EndIf:
+		return fmt.Sprintf("EndIf@%d", v.Offset)
+	default:
+		// unknown instruction
+		panic(fmt.Sprintf("instruction: can't format instruction type %T", i))
+	}
+}
+
+// TextError represents an error in the textual form of a chunk at a
+// particular position.
+type TextError struct {
+	Pos     token.Position // position at which error occurred
+	message error          // the error
+}
+
+// Error implements the error interface.
+func (e *TextError) Error() string {
+	return fmt.Sprintf("ir: %s: %v", e.Pos, e.message)
+}
+
+// Unwrap exposes the underlying error in TextError.
+func (e *TextError) Unwrap() error {
+	return e.message
+}
+
+// textSpec describes the syntax of an instruction in the textual form.
+type textSpec struct {
+	args   int  // number of arguments
+	offset bool // whether the instruction has an offset
+}
+
+// textSpecs stores the specs of every instruction in the textual form.
+var textSpecs = map[string]textSpec{
+	"Value":             {args: 1, offset: true},
+	"Set":               {args: 1, offset: true},
+	"Mul":               {args: 2, offset: true},
+	"Input":             {offset: true},
+	"Output":            {offset: true},
+	"Print":             {args: 1},
+	"StartLoop":         {offset: true},
+	"EndLoop":           {offset: true},
+	"StartBalancedLoop": {offset: true},
+	"EndBalancedLoop":   {offset: true},
+	"If":                {offset: true},
+	"EndIf":             {offset: true},
+}
+
+// ParseText parses the textual form of a chunk, as described by FormatText.
+// Every loop and if must be closed by an instruction of the same kind, so
+// that the resulting chunk can be used by backends. If the chunk is not
+// valid otherwise, it returns a *VerifyError, see Verify.
+func ParseText(src []byte) (*Chunk, error) {
+	var ins []Instruction
+	var n nesting
+
+	var p textParser
+	for _, line := range scan.Lines(src, textError) {
+		if p = (textParser{line}); p.Done() {
+			// empty line or comment
+			continue
+		}
+
+		pos := p.Pos()
+		i, err := p.instruction()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := n.next(i); err != nil {
+			return nil, &TextError{pos, err}
+		}
+
+		ins = append(ins, i)
+	}
+
+	if err := n.end(); err != nil {
+		return nil, &TextError{p.Pos(), err}
+	}
+
+	c := &Chunk{ins: ins}
+	if v := Verify(c); len(v) > 0 {
+		return nil, &VerifyError{v}
+	}
+
+	return c, nil
+}
+
+// textError returns a TextError with the given position and error.
+func textError(pos token.Position, err error) error {
+	return &TextError{pos, err}
+}
+
+// nesting checks that the loops and ifs in a list of instructions are
+// properly nested, one instruction at a time.
+type nesting struct {
+	open []Instruction // unclosed StartLoop and If instructions
+}
+
+// next checks the next instruction in the list.
+func (n *nesting) next(i Instruction) error {
+	switch i.(type) {
+	case StartLoop, If:
+		n.open = append(n.open, i)
+
+	case EndLoop, EndIf:
+		if len(n.open) == 0 {
+			return fmt.Errorf("unexpected %s, no open loop or if", formatInstruction(i))
+		}
+
+		start := n.open[len(n.open)-1]
+		n.open = n.open[:len(n.open)-1]
+
+		if !closes(start, i) {
+			return fmt.Errorf("unexpected %s, %s not closed", formatInstruction(i), formatInstruction(start))
+		}
+	}
+
+	return nil
+}
+
+// end checks that there are no unclosed loops or ifs at the end of the
+// list.
+func (n *nesting) end() error {
+	if len(n.open) > 0 {
+		return fmt.Errorf("unexpected end of input, %s not closed", formatInstruction(n.open[len(n.open)-1]))
+	}
+
+	return nil
+}
+
+// closes checks if the end instruction is of the right kind to close the
+// start instruction.
+func closes(start, end Instruction) bool {
+	switch s := start.(type) {
+	case StartLoop:
+		e, ok := end.(EndLoop)
+		return ok && e.Balanced == s.Balanced
+	case If:
+		_, ok := end.(EndIf)
+		return ok
+	default:
+		return false
+	}
+}
+
+// textParser parses a single line of the textual form of a chunk.
+type textParser struct {
+	scan.Scanner
+}
+
+// instruction parses an instruction and it's spans from the line, which
+// must contain nothing else.
+func (p *textParser) instruction() (Instruction, error) {
+	pos := p.Pos()
+	name := p.Ident()
+	if name == "" {
+		return nil, p.Errorf("expected instruction")
+	}
+
+	s, ok := textSpecs[name]
+	if !ok {
+		return nil, &TextError{pos, fmt.Errorf("unknown instruction %q", name)}
+	}
+
+	// arguments
+	var args []int
+	if s.args > 0 {
+		if err := p.Expect("("); err != nil {
+			return nil, err
+		}
+
+		for len(args) < s.args {
+			if len(args) > 0 {
+				if err := p.Expect(","); err != nil {
+					return nil, err
+				}
+			}
+
+			// the first argument is always a value, which must fit in a
+			// cell as a signed or an unsigned byte
+			min, max := math.MinInt, math.MaxInt
+			if len(args) == 0 {
+				min, max = -128, 255
+			}
+
+			x, err := p.Number(min, max)
+			if err != nil {
+				return nil, err
+			}
+
+			args = append(args, x)
+		}
+
+		if err := p.Expect(")"); err != nil {
+			return nil, err
+		}
+	}
+
+	// offset
+	offset := 0
+	if s.offset {
+		if err := p.Expect("@"); err != nil {
+			return nil, err
+		}
+
+		var err error
+		if offset, err = p.Number(math.MinInt, math.MaxInt); err != nil {
+			return nil, err
+		}
+	}
+
+	// spans
+	var spans Spans
+	if p.Accept(";") {
+		for {
+			span, err := p.Span()
+			if err != nil {
+				return nil, err
+			}
+
+			spans = append(spans, span)
+			if !p.Accept(",") {
+				break
+			}
+		}
+	}
+
+	if !p.Done() {
+		return nil, p.Errorf("unexpected %q after instruction", p.Data[p.Offset])
+	}
+
+	switch name {
+	case "Value":
+		return Value{X: byte(args[0]), Offset: offset, Spans: spans}, nil
+	case "Set":
+		return Set{X: byte(args[0]), Offset: offset, Spans: spans}, nil
+	case "Mul":
+		return Mul{X: byte(args[0]), Source: args[1], Offset: offset, Spans: spans}, nil
+	case "Input":
+		return Input{Offset: offset, Spans: spans}, nil
+	case "Output":
+		return Output{Offset: offset, Spans: spans}, nil
+	case "Print":
+		return Print{X: byte(args[0]), Spans: spans}, nil
+	case "StartLoop", "StartBalancedLoop":
+		return StartLoop{Offset: offset, Balanced: name == "StartBalancedLoop", Spans: spans}, nil
+	case "EndLoop", "EndBalancedLoop":
+		return EndLoop{Offset: offset, Balanced: name == "EndBalancedLoop", Spans: spans}, nil
+	case "If":
+		return If{Offset: offset, Spans: spans}, nil
+	default: // "EndIf"
+		return EndIf{Offset: offset, Spans: spans}, nil
+	}
+}
@@ -0,0 +1,116 @@
+package instruction_test
+
+import (
+	"reflect"
+	"testing"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+	"laptudirm.com/x/brainfuck/pkg/lexer"
+	"laptudirm.com/x/brainfuck/pkg/parser"
+	"laptudirm.com/x/brainfuck/pkg/token"
+)
+
+var textTests = []string{
+	",[.-]",
+	",[-]>,[<+>-]<.",
+	",>,[<[>>+<<-]>[-]]>>.",
+	",[>+<[->+<]>]",
+	",[>>,.<-]\n++++++++[>++++++++<-]>.",
+	",+ +[>-<+++]",
+}
+
+func TestTextRoundTrip(t *testing.T) {
+	for _, src := range textTests {
+		var c instruction.ChunkBuilder
+		chunk, err := parser.ParseWith(lexer.Lex([]byte(src)), &c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		text := instruction.FormatText(chunk)
+		parsed, err := instruction.ParseText(text)
+		if err != nil {
+			t.Errorf("%q: %v\n%s", src, err, text)
+			continue
+		}
+
+		if ins := instructions(parsed); !reflect.DeepEqual(ins, instructions(chunk)) {
+			t.Errorf("%q: expected %v, received %v", src, instructions(chunk), ins)
+		}
+
+		if again := instruction.FormatText(parsed); string(again) != string(text) {
+			t.Errorf("%q: expected text\n%s\nreceived\n%s", src, text, again)
+		}
+	}
+}
+
+func TestParseText(t *testing.T) {
+	src := `
+# print the input until a zero
+Input@0 ; 1:1
+StartBalancedLoop@0 ; 1:2
+	Output@0
+	Mul( -1, 0 )@-1 ; 1:3-1:5, 2:1
+	Value(255)@0
+EndBalancedLoop@0
+If@2
+	Print(72)
+	Set(-1)@2
+EndIf@2
+`
+
+	expected := []instruction.Instruction{
+		instruction.Input{Offset: 0, Spans: span(1, 1)},
+		instruction.StartLoop{Offset: 0, Balanced: true, Spans: span(2, 2)},
+		instruction.Output{Offset: 0},
+		instruction.Mul{X: 255, Source: 0, Offset: -1, Spans: append(span(3, 5), token.Span{
+			Start: token.Position{Line: 2, Column: 1},
+			End:   token.Position{Line: 2, Column: 1},
+		})},
+		instruction.Value{X: 255, Offset: 0},
+		instruction.EndLoop{Offset: 0, Balanced: true},
+		instruction.If{Offset: 2},
+		instruction.Print{X: 72},
+		instruction.Set{X: 255, Offset: 2},
+		instruction.EndIf{Offset: 2},
+	}
+
+	chunk, err := instruction.ParseText([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ins := instructions(chunk); !reflect.DeepEqual(ins, expected) {
+		t.Errorf("expected %v, received %v", expected, ins)
+	}
+}
+
+var textErrorTests = []struct {
+	src string
+	err string
+}{
+	{src: "Loop@0", err: `ir: 1:1: unknown instruction "Loop"`},
+	{src: "Value@0", err: `ir: 1:6: expected "("`},
+	{src: "Value(1, 2)@0", err: `ir: 1:8: expected ")"`},
+	{src: "Set(256)@0", err: "ir: 1:5: number 256 out of range"},
+	{src: "Input", err: `ir: 1:6: expected "@"`},
+	{src: "Output@0 ; 1", err: `ir: 1:13: expected ":"`},
+	{src: "Print(1) 2", err: `ir: 1:10: unexpected '2' after instruction`},
+	{src: "EndLoop@0", err: "ir: 1:1: unexpected EndLoop@0, no open loop or if"},
+	{src: "StartLoop@0\nEndBalancedLoop@0", err: "ir: 2:1: unexpected EndBalancedLoop@0, StartLoop@0 not closed"},
+	{src: "If@0\n", err: "ir: 2:1: unexpected end of input, If@0 not closed"},
+	{src: "Print(1) ; -1:2", err: "ir: 1:12: number -1 out of range"},
+	{
+		src: "StartBalancedLoop@0\nStartLoop@1\nEndLoop@0\nEndBalancedLoop@0",
+		err: "instruction: invalid chunk, 1 violations:\n\t1: StartLoop@1 inside a balanced loop or if",
+	},
+}
+
+func TestParseTextErrors(t *testing.T) {
+	for _, test := range textErrorTests {
+		_, err := instruction.ParseText([]byte(test.src))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%q: expected error %q, received %v", test.src, test.err, err)
+		}
+	}
+}
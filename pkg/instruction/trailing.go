@@ -0,0 +1,127 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instruction
+
+// removeTrailing removes the code at the end of the program which has no
+// observable effect, i.e. the code after the last input or output which
+// only changes the tape. Loops are only removed if they are proven to
+// terminate, since removing an infinite loop changes the program.
+func removeTrailing(ins []Instruction, r *remarks) []Instruction {
+	// find the start of the code after the last input or output
+	start, depth := 0, 0
+	for n, i := range ins {
+		switch i.(type) {
+		case StartLoop, If:
+			depth++
+		case EndLoop, EndIf:
+			depth--
+		case Input, Output, Print:
+			// the enclosing loops may execute it again, so the code after
+			// the outermost enclosing loop is trailing
+			start = n + 1
+			for d := depth; d > 0; start++ {
+				switch ins[start].(type) {
+				case StartLoop, If:
+					d++
+				case EndLoop, EndIf:
+					d--
+				}
+			}
+		}
+	}
+
+	// remove effect-free code from the end, stopping at the first loop
+	// which can't be removed
+	end := len(ins)
+	for end > start {
+		switch ins[end-1].(type) {
+		case EndLoop, EndIf:
+			begin := matchingStart(ins, end-1)
+			if !terminates(ins[begin:end]) {
+				return ins[:end]
+			}
+
+			r.remove(ins[begin].SourceSpans(), ins[end-1].SourceSpans(), "removed: no observable effect")
+
+			end = begin
+
+		case Value, Set, Mul:
+			end--
+
+		default:
+			// unknown instruction, can't be removed
+			return ins[:end]
+		}
+	}
+
+	return ins[:end]
+}
+
+// matchingStart returns the index of the StartLoop or If instruction which
+// matches the EndLoop or EndIf instruction at the given index.
+func matchingStart(ins []Instruction, end int) int {
+	depth := 0
+	for n := end; n >= 0; n-- {
+		switch ins[n].(type) {
+		case EndLoop, EndIf:
+			depth++
+		case StartLoop, If:
+			if depth--; depth == 0 {
+				return n
+			}
+		}
+	}
+
+	// unreachable for valid instructions
+	panic("instruction: unpaired EndLoop or EndIf instruction")
+}
+
+// terminates checks if the given loop or if, including the StartLoop or If
+// and the matching EndLoop or EndIf instructions, is proven to terminate.
+//
+// Ifs terminate if all the loops inside them terminate. Balanced loops
+// terminate if all the loops inside them terminate, and the control cell
+// is only changed by an odd amount in each iteration, since it will then
+// reach zero in at most 256 iterations.
+func terminates(ins []Instruction) bool {
+	body := ins[1 : len(ins)-1]
+
+	// all nested loops and ifs must terminate
+	for n := 0; n < len(body); n++ {
+		switch body[n].(type) {
+		case StartLoop, If:
+			end := matchingEnd(body, n)
+			if !terminates(body[n : end+1]) {
+				return false
+			}
+
+			n = end
+		}
+	}
+
+	switch v := ins[0].(type) {
+	case If:
+		return true
+	case StartLoop:
+		if !v.Balanced {
+			// the pointer movement can't be predicted
+			return false
+		}
+
+		delta, ok := cellDelta(body, v.Offset)
+		return ok && delta%2 == 1
+	default:
+		return false
+	}
+}
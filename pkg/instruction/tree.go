@@ -0,0 +1,206 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instruction
+
+import "fmt"
+
+// Node represents a node of a Tree. All instructions except StartLoop,
+// EndLoop, If, and EndIf are leaf nodes, while loops and ifs are
+// represented by *Loop and *IfBlock nodes which hold their bodies.
+type Node interface {
+	MemOffset() int
+}
+
+// Tree is the tree form of a Chunk, where loops and ifs hold their
+// bodies instead of being delimited by matching instructions.
+type Tree struct {
+	Body []Node
+}
+
+// MemOffset returns 0, since the program starts at the initial cell.
+func (t *Tree) MemOffset() int {
+	return 0
+}
+
+// Loop represents a loop and it's body.
+//
+// The pointer is moved by Entry before the loop is entered, and by Exit
+// at the end of every iteration, after which the tested cell is tested.
+// If the loop is Balanced, the pointer is not moved and both Entry and
+// Exit are the offset of the tested cell, see StartLoop.
+type Loop struct {
+	Entry    int
+	Exit     int
+	Balanced bool
+	Body     []Node
+
+	Spans          // spans of the StartLoop instruction
+	EndSpans Spans // spans of the EndLoop instruction
+}
+
+// MemOffset returns the offset of the cell tested on entering the loop.
+func (l *Loop) MemOffset() int {
+	return l.Entry
+}
+
+// IfBlock represents an if and it's body. The body is executed at most
+// once, if the cell at the given offset is not zero, see If.
+type IfBlock struct {
+	Offset int
+	Body   []Node
+
+	Spans          // spans of the If instruction
+	EndSpans Spans // spans of the EndIf instruction
+}
+
+// MemOffset returns the offset of the tested cell.
+func (i *IfBlock) MemOffset() int {
+	return i.Offset
+}
+
+// NewTree converts the given Chunk into it's tree form.
+func NewTree(c *Chunk) *Tree {
+	body, _ := treeBody(c.ins)
+	return &Tree{Body: body}
+}
+
+// treeBody converts the given instructions into nodes until the end of
+// the instructions or an unpaired EndLoop or EndIf, whose index is returned.
+func treeBody(ins []Instruction) ([]Node, int) {
+	var nodes []Node
+
+	for n := 0; n < len(ins); n++ {
+		switch v := ins[n].(type) {
+		case StartLoop:
+			body, end := treeBody(ins[n+1:])
+			n += end + 1
+
+			nodes = append(nodes, &Loop{
+				Entry:    v.Offset,
+				Exit:     ins[n].(EndLoop).Offset,
+				Balanced: v.Balanced,
+				Body:     body,
+				Spans:    v.Spans,
+				EndSpans: ins[n].(EndLoop).Spans,
+			})
+
+		case If:
+			body, end := treeBody(ins[n+1:])
+			n += end + 1
+
+			nodes = append(nodes, &IfBlock{
+				Offset:   v.Offset,
+				Body:     body,
+				Spans:    v.Spans,
+				EndSpans: ins[n].(EndIf).Spans,
+			})
+
+		case EndLoop, EndIf:
+			return nodes, n
+
+		default:
+			nodes = append(nodes, v)
+		}
+	}
+
+	return nodes, len(ins)
+}
+
+// Chunk converts the tree back into a flat Chunk. It panics if the tree
+// contains any other nodes than those produced by NewTree, like StartLoop
+// instructions.
+func (t *Tree) Chunk() *Chunk {
+	return &Chunk{ins: flatten(nil, t.Body)}
+}
+
+// flatten appends the instructions of the given nodes to dst and returns
+// the result.
+func flatten(dst []Instruction, nodes []Node) []Instruction {
+	for _, node := range nodes {
+		switch v := node.(type) {
+		case *Loop:
+			dst = append(dst, StartLoop{Offset: v.Entry, Balanced: v.Balanced, Spans: v.Spans})
+			dst = flatten(dst, v.Body)
+			dst = append(dst, EndLoop{Offset: v.Exit, Balanced: v.Balanced, Spans: v.EndSpans})
+
+		case *IfBlock:
+			dst = append(dst, If{Offset: v.Offset, Spans: v.Spans})
+			dst = flatten(dst, v.Body)
+			dst = append(dst, EndIf{Offset: v.Offset, Spans: v.EndSpans})
+
+		case Value, Set, Mul, Input, Output, Print:
+			dst = append(dst, v.(Instruction))
+
+		default:
+			panic(fmt.Sprintf("instruction: invalid node type %T in tree", node))
+		}
+	}
+
+	return dst
+}
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children of
+// node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses a tree in depth-first order: It starts by calling
+// v.Visit(node); node must not be nil. If the visitor w returned by
+// v.Visit(node) is not nil, Walk is invoked recursively with visitor w for
+// each of the children of node, followed by a call of w.Visit(nil).
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	// walk children
+	switch n := node.(type) {
+	case *Tree:
+		walkList(v, n.Body)
+	case *Loop:
+		walkList(v, n.Body)
+	case *IfBlock:
+		walkList(v, n.Body)
+	}
+
+	v.Visit(nil)
+}
+
+// walkList walks each of the given nodes with the given visitor.
+func walkList(v Visitor, nodes []Node) {
+	for _, node := range nodes {
+		Walk(v, node)
+	}
+}
+
+// inspector is a Visitor which calls a function for each node.
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+
+	return nil
+}
+
+// Inspect traverses a tree in depth-first order: It starts by calling
+// f(node); node must not be nil. If f returns true, Inspect invokes f
+// recursively for each of the children of node, followed by a call of
+// f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -0,0 +1,62 @@
+package instruction_test
+
+import (
+	"reflect"
+	"testing"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+)
+
+var treeTests = []string{
+	",[.-]",
+	",>,<[>[->+<]<-]",
+	",[>,[<]>]",
+	",[>+.<[-]]",
+	",>----[<.>-]>,[.>]",
+}
+
+func TestTree(t *testing.T) {
+	for _, src := range treeTests {
+		c := build(src)
+		tree := instruction.NewTree(c)
+
+		if ins := instructions(tree.Chunk()); !reflect.DeepEqual(ins, instructions(c)) {
+			t.Errorf("%s: expected %v, received %v", src, instructions(c), ins)
+		}
+
+		// every loop and if in the chunk should be a node in the tree
+		var loops, ifs int
+		instruction.Inspect(tree, func(n instruction.Node) bool {
+			switch n.(type) {
+			case *instruction.Loop:
+				loops++
+			case *instruction.IfBlock:
+				ifs++
+			}
+
+			return true
+		})
+
+		for _, i := range instructions(c) {
+			switch i.(type) {
+			case instruction.StartLoop:
+				loops--
+			case instruction.If:
+				ifs--
+			}
+		}
+
+		if loops != 0 || ifs != 0 {
+			t.Errorf("%s: loops and ifs in tree don't match the chunk", src)
+		}
+	}
+}
+
+func TestTreeLoop(t *testing.T) {
+	tree := instruction.NewTree(build(",[>]"))
+
+	expected := &instruction.Loop{Entry: 0, Exit: 1}
+	if loop := tree.Body[1]; !reflect.DeepEqual(loop, expected) {
+		t.Errorf("expected %+v, received %+v", expected, loop)
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package instruction
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Bounds of the offsets of instructions in a valid chunk. Offsets must fit
+// in 32 bits, so that backends can encode them as immediate values. On
+// 32-bit platforms every int is within these bounds, so offsets are never
+// out of bounds there.
+const (
+	MinOffset = math.MinInt32
+	MaxOffset = math.MaxInt32
+)
+
+// Violation represents an instruction which makes a chunk invalid.
+type Violation struct {
+	Index   int    // index of the instruction
+	Message string // description of the violation
+}
+
+// String returns a string representation of a violation in the format
+// <index>: <message>.
+func (v Violation) String() string {
+	return fmt.Sprintf("%d: %s", v.Index, v.Message)
+}
+
+// VerifyError represents the violations of an invalid chunk.
+type VerifyError struct {
+	Violations []Violation
+}
+
+// Error implements the error interface.
+func (e *VerifyError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "instruction: invalid chunk, %d violations:", len(e.Violations))
+	for _, v := range e.Violations {
+		b.WriteString("\n\t")
+		b.WriteString(v.String())
+	}
+
+	return b.String()
+}
+
+// Verify checks that the given chunk is valid, and returns all of it's
+// violations sorted by their indices. A chunk is valid if:
+//
+//   - it is composed only of the instructions defined in this package.
+//   - every StartLoop is closed by an EndLoop, and every If by an EndIf,
+//     with loops and ifs properly nested inside each other.
+//   - the StartLoop and EndLoop of a loop are both balanced or both not,
+//     and those of a balanced loop have the same offset, like the If and
+//     EndIf of an if.
+//   - the loops inside balanced loops and ifs are balanced, since they
+//     don't move the pointer.
+//   - every offset, including the source of a Mul, is between MinOffset
+//     and MaxOffset.
+//   - the spans of every instruction don't start after they end.
+//
+// Values are bytes, so they always fit in the 8-bit cells.
+func Verify(c *Chunk) []Violation {
+	var violations []Violation
+	report := func(n int, format string, a ...interface{}) {
+		violations = append(violations, Violation{n, fmt.Sprintf(format, a...)})
+	}
+
+	var open []int // indices of unclosed loops and ifs
+	for n, i := range c.ins {
+		var offsets []int
+
+		switch v := i.(type) {
+		case Print:
+			// doesn't have an offset
+
+		case Value, Set, Input, Output:
+			offsets = []int{v.MemOffset()}
+
+		case Mul:
+			offsets = []int{v.Source, v.Offset}
+
+		case StartLoop, If:
+			offsets = []int{v.MemOffset()}
+			if s, ok := v.(StartLoop); ok && !s.Balanced && len(open) > 0 && !isUnbalanced(c.ins[open[len(open)-1]]) {
+				report(n, "%s inside a balanced loop or if", formatInstruction(i))
+			}
+
+			open = append(open, n)
+
+		case EndLoop, EndIf:
+			offsets = []int{v.MemOffset()}
+			if len(open) == 0 {
+				report(n, "%s not opened", formatInstruction(i))
+				break
+			}
+
+			start := c.ins[open[len(open)-1]]
+			open = open[:len(open)-1]
+
+			if !closes(start, i) {
+				report(n, "%s can't close %s", formatInstruction(i), formatInstruction(start))
+				break
+			}
+
+			if s, ok := start.(StartLoop); ok && !s.Balanced {
+				// unbalanced loops test different cells at the start and
+				// end of an iteration
+				break
+			}
+
+			if start.MemOffset() != i.MemOffset() {
+				report(n, "%s has a different offset than %s", formatInstruction(i), formatInstruction(start))
+			}
+
+		default:
+			report(n, "unknown instruction type %T", i)
+			continue
+		}
+
+		for _, offset := range offsets {
+			// never true on 32-bit platforms
+			if offset < MinOffset || offset > MaxOffset {
+				report(n, "offset %d out of bounds", offset)
+			}
+		}
+
+		for _, span := range i.SourceSpans() {
+			if span.End.Before(span.Start) {
+				report(n, "span %s starts after it ends", span)
+			}
+		}
+	}
+
+	for _, n := range open {
+		report(n, "%s not closed", formatInstruction(c.ins[n]))
+	}
+
+	// unclosed loops are reported last, but may come before others
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Index < violations[j].Index
+	})
+
+	return violations
+}
+
+// isUnbalanced checks if the given instruction starts an unbalanced loop.
+func isUnbalanced(i Instruction) bool {
+	s, ok := i.(StartLoop)
+	return ok && !s.Balanced
+}
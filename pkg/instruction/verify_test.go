@@ -0,0 +1,65 @@
+package instruction_test
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+	"laptudirm.com/x/brainfuck/pkg/token"
+)
+
+func TestVerify(t *testing.T) {
+	for _, src := range textTests {
+		if v := instruction.Verify(build(src)); v != nil {
+			t.Errorf("%q: unexpected violations %v", src, v)
+		}
+	}
+
+	// invalid chunks can be created from modified trees
+	tree := &instruction.Tree{Body: []instruction.Node{
+		&instruction.Loop{Entry: 0, Exit: 1, Balanced: true, Body: []instruction.Node{
+			instruction.Mul{X: 2, Source: 0, Offset: 1},
+			&instruction.Loop{Entry: 1, Exit: 0},
+		}},
+		&instruction.IfBlock{Offset: 2},
+		instruction.Print{X: 1, Spans: instruction.Spans{{
+			Start: token.Position{Line: 1, Column: 5},
+			End:   token.Position{Line: 1, Column: 2},
+		}}},
+	}}
+
+	expected := []instruction.Violation{
+		{Index: 2, Message: "StartLoop@1 inside a balanced loop or if"},
+		{Index: 4, Message: "EndBalancedLoop@1 has a different offset than StartBalancedLoop@0"},
+		{Index: 7, Message: "span 1:5-1:2 starts after it ends"},
+	}
+
+	if v := instruction.Verify(tree.Chunk()); !reflect.DeepEqual(v, expected) {
+		t.Errorf("expected %v, received %v", expected, v)
+	}
+}
+
+func TestVerifyOffsets(t *testing.T) {
+	if math.MaxInt == math.MaxInt32 {
+		t.Skip("offsets are never out of bounds on 32-bit platforms")
+	}
+
+	// the offsets are computed at run time, since they overflow an int
+	// on 32-bit platforms
+	over, under := int64(instruction.MaxOffset)+1, int64(instruction.MinOffset)-1
+
+	tree := &instruction.Tree{Body: []instruction.Node{
+		instruction.Value{X: 1, Offset: int(over)},
+		instruction.Mul{X: 2, Source: int(under), Offset: 1},
+	}}
+
+	expected := []instruction.Violation{
+		{Index: 0, Message: "offset 2147483648 out of bounds"},
+		{Index: 1, Message: "offset -2147483649 out of bounds"},
+	}
+
+	if v := instruction.Verify(tree.Chunk()); !reflect.DeepEqual(v, expected) {
+		t.Errorf("expected %v, received %v", expected, v)
+	}
+}
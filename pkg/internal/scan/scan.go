@@ -0,0 +1,201 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package scan implements a scanner for the line based text formats of
+// the module, like the textual form of chunks and rules files. Lines are
+// scanned one at a time, tokens are separated by optional whitespace, and
+// comments start with a # and continue till the end of the line.
+package scan
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+
+	"laptudirm.com/x/brainfuck/pkg/token"
+)
+
+// Scanner is a state machine which represents the current scanning state
+// of a single line.
+type Scanner struct {
+	Data   []byte // data of the line
+	Line   int    // line number
+	Offset int    // current offset within data
+
+	// Error wraps an error at the given position into the error type of
+	// the format.
+	Error func(pos token.Position, err error) error
+}
+
+// Lines splits the given source into lines, and returns a Scanner for each
+// of them, which uses the given error function.
+func Lines(src []byte, errorFunc func(token.Position, error) error) []Scanner {
+	lines := bytes.Split(src, []byte("\n"))
+	scanners := make([]Scanner, len(lines))
+	for n, line := range lines {
+		scanners[n] = Scanner{Data: line, Line: n + 1, Error: errorFunc}
+	}
+
+	return scanners
+}
+
+// Ident scans an identifier made of letters, digits and underscores, which
+// doesn't start with a digit, and returns an empty string if there is none.
+func (s *Scanner) Ident() string {
+	s.Space()
+	start := s.Offset
+	for s.Offset < len(s.Data) && isIdent(s.Data[s.Offset], s.Offset == start) {
+		s.Offset++
+	}
+
+	return string(s.Data[start:s.Offset])
+}
+
+// Digits scans a decimal number without a sign, and returns it's digits,
+// or an empty string if there is none. Whitespace isn't skipped, so that a
+// sign can be scanned right before it.
+func (s *Scanner) Digits() string {
+	start := s.Offset
+	for s.Offset < len(s.Data) && IsDigit(s.Data[s.Offset]) {
+		s.Offset++
+	}
+
+	return string(s.Data[start:s.Offset])
+}
+
+// Number scans a decimal integer, which may be negative, and which must be
+// between min and max.
+func (s *Scanner) Number(min, max int) (int, error) {
+	s.Space()
+	pos := s.Pos()
+
+	start := s.Offset
+	if s.Peek("-") {
+		s.Offset++
+	}
+
+	if s.Digits() == "" {
+		s.Offset = start
+		return 0, s.Errorf("expected number")
+	}
+
+	text := s.Data[start:s.Offset]
+	x, err := strconv.Atoi(string(text))
+	if err != nil || x < min || x > max {
+		return 0, s.Error(pos, fmt.Errorf("number %s out of range", text))
+	}
+
+	return x, nil
+}
+
+// Span scans a span, which is either a single position or two positions
+// separated by a -.
+func (s *Scanner) Span() (token.Span, error) {
+	start, err := s.Position()
+	if err != nil {
+		return token.Span{}, err
+	}
+
+	end := start
+	if s.Accept("-") {
+		if end, err = s.Position(); err != nil {
+			return token.Span{}, err
+		}
+	}
+
+	return token.Span{Start: start, End: end}, nil
+}
+
+// Position scans a position in the format <line>:<column>.
+func (s *Scanner) Position() (token.Position, error) {
+	line, err := s.Number(0, math.MaxInt)
+	if err != nil {
+		return token.Position{}, err
+	}
+
+	if err := s.Expect(":"); err != nil {
+		return token.Position{}, err
+	}
+
+	column, err := s.Number(0, math.MaxInt)
+	if err != nil {
+		return token.Position{}, err
+	}
+
+	return token.Position{Line: line, Column: column}, nil
+}
+
+// Expect consumes the given string, or returns an error if it is not next.
+func (s *Scanner) Expect(str string) error {
+	if !s.Accept(str) {
+		return s.Errorf("expected %q", str)
+	}
+
+	return nil
+}
+
+// Accept consumes the given string if it is next, and reports whether it
+// was consumed.
+func (s *Scanner) Accept(str string) bool {
+	if s.Space(); !s.Peek(str) {
+		return false
+	}
+
+	s.Offset += len(str)
+	return true
+}
+
+// Peek checks if the given string is next, without skipping whitespace.
+func (s *Scanner) Peek(str string) bool {
+	return bytes.HasPrefix(s.Data[s.Offset:], []byte(str))
+}
+
+// Space skips any whitespace.
+func (s *Scanner) Space() {
+	for s.Offset < len(s.Data) && isSpace(s.Data[s.Offset]) {
+		s.Offset++
+	}
+}
+
+// Done checks if the rest of the line is empty or a comment.
+func (s *Scanner) Done() bool {
+	s.Space()
+	return s.Offset == len(s.Data) || s.Data[s.Offset] == '#'
+}
+
+// Pos returns the current position in the text.
+func (s *Scanner) Pos() token.Position {
+	return token.Position{Line: s.Line, Column: s.Offset + 1}
+}
+
+// Errorf returns an error at the current position.
+func (s *Scanner) Errorf(format string, a ...interface{}) error {
+	return s.Error(s.Pos(), fmt.Errorf(format, a...))
+}
+
+// isSpace checks if the given byte is whitespace.
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\r'
+}
+
+// IsDigit checks if the given byte is a decimal digit.
+func IsDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
+
+// isIdent checks if the given byte can be a part of an identifier, where
+// first reports whether it is the first byte.
+func isIdent(b byte, first bool) bool {
+	return b == '_' || ('a' <= b && b <= 'z') || ('A' <= b && b <= 'Z') || (!first && IsDigit(b))
+}
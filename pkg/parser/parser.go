@@ -24,14 +24,22 @@ import (
 
 // Parse parses a brainfuck token stream into an abstract syntax tree.
 func Parse(tokens <-chan token.Token) (*instruction.Chunk, error) {
-	p := parser{tokens: tokens}
+	return ParseWith(tokens, &instruction.ChunkBuilder{})
+}
+
+// ParseWith is like Parse but builds the chunk using the provided
+// ChunkBuilder, so that it can be inspected after parsing, for example to
+// get the optimization remarks.
+func ParseWith(tokens <-chan token.Token, c *instruction.ChunkBuilder) (*instruction.Chunk, error) {
+	p := parser{tokens: tokens, builder: c}
 	return p.program()
 }
 
 // parser is a state machine which represents the current parsing state.
 type parser struct {
-	tokens  <-chan token.Token // token stream
-	current token.Token        // current token
+	tokens  <-chan token.Token        // token stream
+	current token.Token               // current token
+	builder *instruction.ChunkBuilder // chunk builder
 }
 
 // SyntaxError represents a brainfuck syntax error at a particular token.
@@ -58,12 +66,15 @@ var (
 
 // program parses a brainfuck program from the token stream.
 func (p *parser) program() (*instruction.Chunk, error) {
-	var c instruction.ChunkBuilder
+	c := p.builder
 	var stack []token.Token // loop stack
 
 parseLoop:
 	for {
-		switch p.next(); p.current.Type {
+		p.next()
+		c.SetPosition(p.current.Position)
+
+		switch p.current.Type {
 
 		// end of token stream
 		case token.Eof:
@@ -0,0 +1,128 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package reference implements a naive brainfuck interpreter which executes
+// a token stream directly, one command at a time. It is meant to be
+// obviously correct rather than fast, and is used as a reference to test
+// the optimizer and the compilation targets against.
+package reference
+
+import (
+	"errors"
+	"io"
+
+	"laptudirm.com/x/brainfuck/pkg/token"
+)
+
+// Interpreter represents the state of a brainfuck program being
+// interpreted. The Memory field must be set before calling Run.
+type Interpreter struct {
+	Memory  []byte // memory tape
+	Pointer int    // memory pointer
+
+	Input  io.Reader // input stream, the cell is unchanged on EOF
+	Output io.Writer // output stream
+
+	// MaxSteps is the maximum number of commands which are executed before
+	// giving up, or 0 for no limit.
+	MaxSteps int
+}
+
+// Errors returned by the interpreter.
+var (
+	ErrUnmatched   = errors.New("reference: unmatched bracket")
+	ErrOutOfBounds = errors.New("reference: pointer out of bounds")
+	ErrMaxSteps    = errors.New("reference: maximum number of steps exceeded")
+)
+
+// Run executes the brainfuck program in the given token stream.
+func (i *Interpreter) Run(tokens <-chan token.Token) error {
+	// read the whole program
+	var program []token.Type
+	for tok := range tokens {
+		program = append(program, tok.Type)
+	}
+
+	// find matching brackets
+	jumps := make([]int, len(program))
+	var stack []int
+	for pc, tok := range program {
+		switch tok {
+		case token.LeftBracket:
+			stack = append(stack, pc)
+		case token.RightBracket:
+			if len(stack) == 0 {
+				return ErrUnmatched
+			}
+
+			start := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			jumps[start], jumps[pc] = pc, start
+		}
+	}
+
+	if len(stack) > 0 {
+		return ErrUnmatched
+	}
+
+	if i.Pointer < 0 || i.Pointer >= len(i.Memory) {
+		return ErrOutOfBounds
+	}
+
+	steps := 0
+	for pc := 0; pc < len(program); pc++ {
+		if steps++; i.MaxSteps > 0 && steps > i.MaxSteps {
+			return ErrMaxSteps
+		}
+
+		switch program[pc] {
+		case token.Plus:
+			i.Memory[i.Pointer]++
+		case token.Minus:
+			i.Memory[i.Pointer]--
+
+		case token.LeftArrow:
+			if i.Pointer--; i.Pointer < 0 {
+				return ErrOutOfBounds
+			}
+		case token.RightArrow:
+			if i.Pointer++; i.Pointer >= len(i.Memory) {
+				return ErrOutOfBounds
+			}
+
+		case token.Comma:
+			var b [1]byte
+			n, err := io.ReadFull(i.Input, b[:])
+			if n == 1 {
+				i.Memory[i.Pointer] = b[0]
+			} else if err != io.EOF {
+				return err
+			}
+		case token.Period:
+			if _, err := i.Output.Write([]byte{i.Memory[i.Pointer]}); err != nil {
+				return err
+			}
+
+		case token.LeftBracket:
+			if i.Memory[i.Pointer] == 0 {
+				pc = jumps[pc]
+			}
+		case token.RightBracket:
+			if i.Memory[i.Pointer] != 0 {
+				pc = jumps[pc]
+			}
+		}
+	}
+
+	return nil
+}
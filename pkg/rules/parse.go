@@ -0,0 +1,259 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rules
+
+import (
+	"fmt"
+	"strconv"
+
+	"laptudirm.com/x/brainfuck/pkg/internal/scan"
+	"laptudirm.com/x/brainfuck/pkg/token"
+)
+
+// Error represents an error in a rules file at a particular position.
+type Error struct {
+	Pos     token.Position // position at which error occurred
+	message error          // the error
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	return fmt.Sprintf("rules: %s: %v", e.Pos, e.message)
+}
+
+// Unwrap exposes the underlying error in Error.
+func (e *Error) Unwrap() error {
+	return e.message
+}
+
+// Parse parses the rules in the given source and checks that they are
+// well-formed, i.e. that their instructions exist and have the right
+// arguments, that values are in range, that variables are used either as
+// values or as offsets, and that the variables in replacements are bound
+// by the patterns. Rule names must be unique.
+func Parse(src []byte) ([]*Rule, error) {
+	var rules []*Rule
+	names := make(map[string]bool)
+
+	for _, line := range scan.Lines(src, ruleError) {
+		p := parser{Scanner: line}
+		if p.Done() {
+			// empty line or comment
+			continue
+		}
+
+		pos := p.Pos()
+		r, err := p.rule()
+		if err != nil {
+			return nil, err
+		}
+
+		if names[r.Name] {
+			return nil, &Error{pos, fmt.Errorf("duplicate rule %q", r.Name)}
+		}
+
+		names[r.Name] = true
+		rules = append(rules, r)
+	}
+
+	return rules, nil
+}
+
+// ruleError returns an Error with the given position and error.
+func ruleError(pos token.Position, err error) error {
+	return &Error{pos, err}
+}
+
+// parser parses a single line of a rules file.
+type parser struct {
+	scan.Scanner
+
+	pattern bool            // whether a pattern is being parsed
+	kinds   map[string]kind // kinds of the variables bound by the pattern
+}
+
+// rule parses a rule from the line, which must contain nothing else.
+func (p *parser) rule() (*Rule, error) {
+	var r Rule
+	var err error
+
+	if r.Name, err = p.ident("rule name"); err != nil {
+		return nil, err
+	}
+
+	if err := p.Expect(":"); err != nil {
+		return nil, err
+	}
+
+	if err := p.Expect("["); err != nil {
+		return nil, err
+	}
+
+	p.pattern, p.kinds = true, make(map[string]kind)
+	if r.pattern, err = p.templates("]"); err != nil {
+		return nil, err
+	}
+
+	if err := p.Expect("]"); err != nil {
+		return nil, err
+	}
+
+	if err := p.Expect("=>"); err != nil {
+		return nil, err
+	}
+
+	p.pattern = false
+	if r.replacement, err = p.templates(""); err != nil {
+		return nil, err
+	}
+
+	if !p.Done() {
+		return nil, p.Errorf("unexpected %q after rule", p.Data[p.Offset])
+	}
+
+	return &r, nil
+}
+
+// templates parses a comma separated list of instructions which ends with
+// the given string, or the end of the line if it is empty.
+func (p *parser) templates(end string) ([]template, error) {
+	if p.Space(); (end == "" && p.Done()) || (end != "" && p.Peek(end)) {
+		// empty list
+		return nil, nil
+	}
+
+	var templates []template
+	for {
+		t, err := p.template()
+		if err != nil {
+			return nil, err
+		}
+
+		templates = append(templates, t)
+		if !p.Accept(",") {
+			return templates, nil
+		}
+	}
+}
+
+// template parses an instruction.
+func (p *parser) template() (template, error) {
+	p.Space()
+	pos := p.Pos()
+	name, err := p.ident("instruction")
+	if err != nil {
+		return template{}, err
+	}
+
+	s, ok := specs[name]
+	if !ok {
+		return template{}, &Error{pos, fmt.Errorf("unknown instruction %q", name)}
+	}
+
+	t := template{name: name}
+	args := len(s.fields)
+	if s.offset {
+		args--
+	}
+
+	// arguments
+	if p.Accept("(") && !p.Accept(")") {
+		for {
+			if len(t.terms) == args {
+				return template{}, &Error{pos, fmt.Errorf("too many arguments to %s", name)}
+			}
+
+			term, err := p.term(s.fields[len(t.terms)])
+			if err != nil {
+				return template{}, err
+			}
+
+			t.terms = append(t.terms, term)
+			if p.Accept(")") {
+				break
+			}
+
+			if err := p.Expect(","); err != nil {
+				return template{}, err
+			}
+		}
+	}
+
+	if len(t.terms) != args {
+		return template{}, &Error{pos, fmt.Errorf("not enough arguments to %s", name)}
+	}
+
+	// offset
+	if s.offset {
+		if err := p.Expect("@"); err != nil {
+			return template{}, err
+		}
+
+		term, err := p.term(offset)
+		if err != nil {
+			return template{}, err
+		}
+
+		t.terms = append(t.terms, term)
+	}
+
+	return t, nil
+}
+
+// term parses a number or a variable of the given kind.
+func (p *parser) term(k kind) (term, error) {
+	p.Space()
+	pos := p.Pos()
+	neg := p.Accept("-")
+
+	p.Space()
+	if digits := p.Digits(); digits != "" {
+		x, err := strconv.Atoi(digits)
+		if err != nil || (k == value && x > 255) {
+			return term{}, &Error{pos, fmt.Errorf("value %s out of range", digits)}
+		}
+
+		if neg {
+			x = -x
+		}
+
+		return term{x: x}, nil
+	}
+
+	name, err := p.ident("number or variable")
+	if err != nil {
+		return term{}, err
+	}
+
+	bound, ok := p.kinds[name]
+	switch {
+	case ok && bound != k:
+		return term{}, &Error{pos, fmt.Errorf("variable %s used as both a value and an offset", name)}
+	case !ok && !p.pattern:
+		return term{}, &Error{pos, fmt.Errorf("variable %s not bound by pattern", name)}
+	}
+
+	p.kinds[name] = k
+	return term{variable: name, neg: neg}, nil
+}
+
+// ident parses an identifier, which is described by what in errors.
+func (p *parser) ident(what string) (string, error) {
+	name := p.Ident()
+	if name == "" {
+		return "", p.Errorf("expected %s", what)
+	}
+
+	return name, nil
+}
@@ -0,0 +1,224 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package rules implements a small language for user-defined rewrite rules
+// of loops, which are applied by the optimizer in addition to it's built-in
+// optimizations.
+//
+// A rules file contains a rule on each line. Empty lines are ignored, and
+// comments start with a # and continue till the end of the line. A rule
+// has a name, a pattern which is matched against the body of a loop, and a
+// replacement for the whole loop:
+//
+//	mul: [Value(-1)@0, Value(k)@n] => Mul(k, 0)@n, Set(0)@0
+//
+// Instructions are written as their names followed by their arguments and
+// their offsets after an @. The instructions and their arguments are:
+//
+//	Value(x)@offset       change the cell by x
+//	Set(x)@offset         set the cell to x
+//	Mul(x, source)@offset change the cell by x times the source cell
+//	Input@offset          input a byte into the cell
+//	Output@offset         output the cell
+//	Print(x)              output the byte x
+//
+// Arguments and offsets are either numbers or variables, optionally
+// negated, like -1 or -k. A variable in a pattern matches any value, but
+// all the uses of a variable must match the same value. Every variable in
+// a replacement must be bound by the pattern.
+//
+// Patterns are only matched against the bodies of loops which don't move
+// the pointer and don't contain other loops, after they have been cleaned
+// up by the optimizer. Offsets are relative to the loop's control cell.
+// The replacement is executed instead of the loop even if the control cell
+// is zero, so a rule must have the same effect as the loop for all values
+// of the control cell, including zero.
+package rules
+
+import "laptudirm.com/x/brainfuck/pkg/instruction"
+
+// Rule represents a well-formed rewrite rule. It implements the
+// instruction.LoopRule interface.
+type Rule struct {
+	Name string // name of the rule
+
+	pattern     []template // pattern for the loop body
+	replacement []template // replacement for the loop
+}
+
+// Rewrite returns the replacement for a loop with the given body, if the
+// body matches the rule's pattern.
+func (r *Rule) Rewrite(body []instruction.Instruction) ([]instruction.Instruction, bool) {
+	if len(body) != len(r.pattern) {
+		return nil, false
+	}
+
+	b := make(bindings)
+	for n, t := range r.pattern {
+		if !t.match(body[n], b) {
+			return nil, false
+		}
+	}
+
+	ins := make([]instruction.Instruction, len(r.replacement))
+	for n, t := range r.replacement {
+		ins[n] = t.build(b)
+	}
+
+	return ins, true
+}
+
+// bindings stores the values matched by the variables of a pattern.
+type bindings map[string]int
+
+// kind represents the kind of value held by an instruction field.
+type kind int
+
+const (
+	value  kind = iota // cell value, which wraps at 256
+	offset             // offset of a cell
+)
+
+// spec describes the syntax of an instruction.
+type spec struct {
+	fields []kind // kinds of the fields, i.e. the arguments and the offset
+	offset bool   // whether the last field is written as an offset
+}
+
+// specs stores the specs of the instructions which can be used in rules.
+var specs = map[string]spec{
+	"Value":  {fields: []kind{value, offset}, offset: true},
+	"Set":    {fields: []kind{value, offset}, offset: true},
+	"Mul":    {fields: []kind{value, offset, offset}, offset: true},
+	"Input":  {fields: []kind{offset}, offset: true},
+	"Output": {fields: []kind{offset}, offset: true},
+	"Print":  {fields: []kind{value}},
+}
+
+// fields returns the name and the fields of the given instruction, in the
+// order used by specs. Instructions which can't be used in rules have no
+// name.
+func fields(i instruction.Instruction) (string, []int) {
+	switch v := i.(type) {
+	case instruction.Value:
+		return "Value", []int{int(v.X), v.Offset}
+	case instruction.Set:
+		return "Set", []int{int(v.X), v.Offset}
+	case instruction.Mul:
+		return "Mul", []int{int(v.X), v.Source, v.Offset}
+	case instruction.Input:
+		return "Input", []int{v.Offset}
+	case instruction.Output:
+		return "Output", []int{v.Offset}
+	case instruction.Print:
+		return "Print", []int{int(v.X)}
+	default:
+		return "", nil
+	}
+}
+
+// template represents an instruction whose fields are terms.
+type template struct {
+	name  string // name of the instruction
+	terms []term // terms for each field
+}
+
+// match checks if the given instruction matches the template, binding
+// any unbound variables in b.
+func (t template) match(i instruction.Instruction, b bindings) bool {
+	name, f := fields(i)
+	if name != t.name {
+		return false
+	}
+
+	kinds := specs[name].fields
+	for n, term := range t.terms {
+		x := f[n]
+		if term.neg {
+			x = -x
+		}
+
+		x = normalize(x, kinds[n])
+
+		if term.variable == "" {
+			if normalize(term.x, kinds[n]) != x {
+				return false
+			}
+
+			continue
+		}
+
+		if y, ok := b[term.variable]; ok && y != x {
+			return false
+		}
+
+		b[term.variable] = x
+	}
+
+	return true
+}
+
+// build returns the instruction described by the template, using the
+// values of the variables in b, which must all be bound.
+func (t template) build(b bindings) instruction.Instruction {
+	kinds := specs[t.name].fields
+	f := make([]int, len(t.terms))
+
+	for n, term := range t.terms {
+		x := term.x
+		if term.variable != "" {
+			x = b[term.variable]
+		}
+
+		if term.neg {
+			x = -x
+		}
+
+		f[n] = normalize(x, kinds[n])
+	}
+
+	switch t.name {
+	case "Value":
+		return instruction.Value{X: byte(f[0]), Offset: f[1]}
+	case "Set":
+		return instruction.Set{X: byte(f[0]), Offset: f[1]}
+	case "Mul":
+		return instruction.Mul{X: byte(f[0]), Source: f[1], Offset: f[2]}
+	case "Input":
+		return instruction.Input{Offset: f[0]}
+	case "Output":
+		return instruction.Output{Offset: f[0]}
+	case "Print":
+		return instruction.Print{X: byte(f[0])}
+	default:
+		// unreachable for well-formed rules
+		panic("rules: invalid instruction " + t.name)
+	}
+}
+
+// term represents a number or a variable, which may be negated.
+type term struct {
+	variable string // name of the variable, or empty for numbers
+	x        int    // value of the number
+	neg      bool   // whether the variable is negated
+}
+
+// normalize returns x as a value of the given kind.
+func normalize(x int, k kind) int {
+	if k == value {
+		// cell values wrap at 256
+		return int(byte(x))
+	}
+
+	return x
+}
@@ -0,0 +1,138 @@
+package rules_test
+
+import (
+	"reflect"
+	"testing"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+	"laptudirm.com/x/brainfuck/pkg/lexer"
+	"laptudirm.com/x/brainfuck/pkg/parser"
+	"laptudirm.com/x/brainfuck/pkg/rules"
+	"laptudirm.com/x/brainfuck/pkg/token"
+)
+
+const source = `
+# clear loops which change the cell by an even amount never terminate if
+# the cell is odd, so they aren't optimized by default
+clear: [Value(-2)@0] => Set(0)@0
+
+# loops which print a cell until it is cleared
+show: [Output@0, Value(-1)@0] => Output@0, Set(0)@0 # ignores the rest
+
+# a slightly different multiplication
+mul: [Value(k)@n, Value(-2)@0, Value(-k)@m] => Mul(k, 0)@n, Mul(-k, 0)@m, Set(0)@0
+`
+
+// span returns the spans of the given columns on the first line.
+func span(start, end int) instruction.Spans {
+	return instruction.Spans{{
+		Start: token.Position{Line: 1, Column: start},
+		End:   token.Position{Line: 1, Column: end},
+	}}
+}
+
+var rewriteTests = []struct {
+	src string
+	out []instruction.Instruction
+}{
+	{
+		src: ",[--]",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0, Spans: span(1, 1)},
+			instruction.Set{X: 0, Offset: 0, Spans: span(2, 5)},
+		},
+	},
+	{
+		src: ",>,[.-]",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0, Spans: span(1, 1)},
+			instruction.Input{Offset: 1, Spans: span(3, 3)},
+			instruction.Output{Offset: 1, Spans: span(4, 7)},
+			instruction.Set{X: 0, Offset: 1, Spans: span(4, 7)},
+		},
+	},
+	{
+		src: ",[>+++<-->>---<<]",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0, Spans: span(1, 1)},
+			instruction.Mul{X: 3, Source: 0, Offset: 1, Spans: span(2, 17)},
+			instruction.Mul{X: 253, Source: 0, Offset: 2, Spans: span(2, 17)},
+			instruction.Set{X: 0, Offset: 0, Spans: span(2, 17)},
+		},
+	},
+	{
+		// k doesn't match
+		src: ",[>+++<-->>--<<]",
+		out: []instruction.Instruction{
+			instruction.Input{Offset: 0, Spans: span(1, 1)},
+			instruction.StartLoop{Offset: 0, Balanced: true, Spans: span(2, 2)},
+			instruction.Value{X: 3, Offset: 1, Spans: span(4, 6)},
+			instruction.Value{X: 254, Offset: 0, Spans: span(8, 9)},
+			instruction.Value{X: 254, Offset: 2, Spans: span(12, 13)},
+			instruction.EndLoop{Offset: 0, Balanced: true, Spans: span(16, 16)},
+		},
+	},
+}
+
+func TestRewrite(t *testing.T) {
+	// without the rules, even clear loops are kept
+	chunk, err := parser.Parse(lexer.Lex([]byte(",[--]")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if chunk.Len() != 4 {
+		t.Errorf(",[--]: expected a loop without rules, received %v", chunk)
+	}
+
+	r, err := rules.Parse([]byte(source))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := instruction.ChunkBuilder{KeepTrailing: true}
+	for _, rule := range r {
+		c.Rules = append(c.Rules, rule)
+	}
+
+	for _, test := range rewriteTests {
+		b := c
+		chunk, err = parser.ParseWith(lexer.Lex([]byte(test.src)), &b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ins := make([]instruction.Instruction, chunk.Len())
+		for n := range ins {
+			ins[n] = chunk.Instruction(n)
+		}
+
+		if !reflect.DeepEqual(ins, test.out) {
+			t.Errorf("%s: expected %v, received %v", test.src, test.out, ins)
+		}
+	}
+}
+
+var errorTests = []struct {
+	src string
+	err string
+}{
+	{src: "a [Value(1)@0] => Set(0)@0", err: `rules: 1:3: expected ":"`},
+	{src: "a: [Loop(1)@0] => Set(0)@0", err: `rules: 1:5: unknown instruction "Loop"`},
+	{src: "a: [Value(1, 2)@0] => Set(0)@0", err: "rules: 1:5: too many arguments to Value"},
+	{src: "a: [Value@0] => Set(0)@0", err: "rules: 1:5: not enough arguments to Value"},
+	{src: "a: [Value(256)@0] => Set(0)@0", err: "rules: 1:11: value 256 out of range"},
+	{src: "a: [Value(k)@k] => Set(0)@0", err: "rules: 1:14: variable k used as both a value and an offset"},
+	{src: "a: [Value(1)@0] => Set(k)@0", err: "rules: 1:24: variable k not bound by pattern"},
+	{src: "a: [Value(1)@0] => Set(0)@0 ]", err: `rules: 1:29: unexpected ']' after rule`},
+	{src: "a: [] => \n\na: [] =>", err: `rules: 3:1: duplicate rule "a"`},
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range errorTests {
+		_, err := rules.Parse([]byte(test.src))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("%s: expected error %q, received %v", test.src, test.err, err)
+		}
+	}
+}
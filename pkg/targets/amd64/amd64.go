@@ -0,0 +1,282 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package amd64 implements the x86-64 assembly compilation target, which
+// compiles an instruction.Chunk into GNU assembly for Linux. The assembly
+// doesn't depend on libc, and can be assembled and linked into a static
+// executable with:
+//
+//	brainfuck build --target=amd64-asm -o prog.s prog.bf
+//	as -o prog.o prog.s
+//	ld -o prog prog.o
+//
+// The memory pointer is stored in %rbx, and cells are accessed using it
+// and the offset of the cell. The pointer is not bounds checked. Input and
+// output are buffered, and use the read and write system calls. Pending
+// output is written before blocking for input, and the program exits with
+// status 1 if a system call fails.
+package amd64
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+	"laptudirm.com/x/brainfuck/pkg/targets"
+)
+
+// Options configures the compiled program.
+type Options struct {
+	TapeSize int         // number of cells, or 0 for targets.DefaultTapeSize
+	EOF      targets.EOF // what input stores in a cell on EOF
+}
+
+// bufferSize is the size of the input and output buffers.
+const bufferSize = 4096
+
+// Compile compiles an instruction.Chunk into GNU assembly for x86-64
+// Linux.
+func Compile(c *instruction.Chunk, o Options) []byte {
+	instruction.VerifyDebug(c)
+
+	tape := o.TapeSize
+	if tape == 0 {
+		tape = targets.DefaultTapeSize
+	}
+
+	var w writer
+	w.raw("# Code generated by brainfuck. DO NOT EDIT.")
+	w.raw("")
+	w.op(".bss")
+	w.op(".lcomm tape, %d", tape)
+	w.op(".lcomm outbuf, %d", bufferSize)
+	w.op(".lcomm inbuf, %d", bufferSize)
+	w.raw("")
+	w.op(".text")
+	w.op(".globl _start")
+	w.raw("_start:")
+
+	// %rbx: memory pointer
+	// %r12: length of the output buffer
+	// %r13, %r14: position in and length of the input buffer
+	w.op("leaq tape(%%rip), %%rbx")
+	w.op("xorl %%r12d, %%r12d")
+	w.op("xorl %%r13d, %%r13d")
+	w.op("xorl %%r14d, %%r14d")
+
+	var loops []int // loop and if stack
+	labels := 0     // number of loop and if labels
+
+	length := c.Len()
+	for i := 0; i < length; i++ {
+		ins := c.Instruction(i)
+		w.raw("")
+		w.op("# %s", ins.Instruction())
+
+		switch v := ins.(type) {
+		case instruction.Value:
+			w.op("addb $%d, %d(%%rbx)", int8(v.X), v.Offset)
+
+		case instruction.Set:
+			w.op("movb $%d, %d(%%rbx)", int8(v.X), v.Offset)
+
+		case instruction.Mul:
+			// the destination must not be accessed if the source is zero
+			w.op("movzbl %d(%%rbx), %%eax", v.Source)
+			w.op("testl %%eax, %%eax")
+			w.op("jz 1f")
+			switch int8(v.X) {
+			case 1:
+				w.op("addb %%al, %d(%%rbx)", v.Offset)
+			case -1:
+				w.op("subb %%al, %d(%%rbx)", v.Offset)
+			default:
+				w.op("imull $%d, %%eax, %%eax", int8(v.X))
+				w.op("addb %%al, %d(%%rbx)", v.Offset)
+			}
+			w.raw("1:")
+
+		case instruction.Input:
+			// getc returns -1 on EOF
+			w.op("call getc")
+			switch o.EOF {
+			case targets.EOFUnchanged:
+				w.op("testl %%eax, %%eax")
+				w.op("js 1f")
+				w.op("movb %%al, %d(%%rbx)", v.Offset)
+				w.raw("1:")
+			case targets.EOFZero:
+				w.op("testl %%eax, %%eax")
+				w.op("jns 1f")
+				w.op("xorl %%eax, %%eax")
+				w.raw("1:")
+				w.op("movb %%al, %d(%%rbx)", v.Offset)
+			case targets.EOFMinusOne:
+				w.op("movb %%al, %d(%%rbx)", v.Offset)
+			}
+
+		case instruction.Output:
+			w.op("movb %d(%%rbx), %%al", v.Offset)
+			w.op("call putc")
+
+		case instruction.Print:
+			w.op("movb $%d, %%al", int8(v.X))
+			w.op("call putc")
+
+		case instruction.StartLoop:
+			labels++
+			loops = append(loops, labels)
+
+			if v.Balanced {
+				w.op("cmpb $0, %d(%%rbx)", v.Offset)
+			} else {
+				// the pointer is moved before the loop is entered, and at
+				// the end of every iteration
+				w.move(v.Offset)
+				w.op("cmpb $0, (%%rbx)")
+			}
+
+			w.op("je .Lend%d", labels)
+			w.raw(".Lloop%d:", labels)
+
+		case instruction.EndLoop:
+			n := loops[len(loops)-1]
+			loops = loops[:len(loops)-1]
+
+			if v.Balanced {
+				w.op("cmpb $0, %d(%%rbx)", v.Offset)
+			} else {
+				w.move(v.Offset)
+				w.op("cmpb $0, (%%rbx)")
+			}
+
+			w.op("jne .Lloop%d", n)
+			w.raw(".Lend%d:", n)
+
+		case instruction.If:
+			labels++
+			loops = append(loops, labels)
+
+			w.op("cmpb $0, %d(%%rbx)", v.Offset)
+			w.op("je .Lend%d", labels)
+
+		case instruction.EndIf:
+			n := loops[len(loops)-1]
+			loops = loops[:len(loops)-1]
+
+			w.raw(".Lend%d:", n)
+
+		default:
+			// unreachable
+			t := reflect.ValueOf(ins).Type() // get instruction type
+			panic(fmt.Sprintf("amd64: compile: invalid instruction type %s in chunk", t))
+		}
+	}
+
+	w.raw("")
+	w.op("# exit(0)")
+	w.op("call flush")
+	w.op("movl $60, %%eax")
+	w.op("xorl %%edi, %%edi")
+	w.op("syscall")
+
+	w.dst.WriteString(runtime)
+	return w.dst.Bytes()
+}
+
+// runtime contains the subroutines used by compiled programs.
+var runtime = fmt.Sprintf(`
+# putc appends the byte in %%al to the output buffer, and flushes it if it
+# is full.
+putc:
+	leaq outbuf(%%rip), %%rsi
+	movb %%al, (%%rsi,%%r12)
+	incq %%r12
+	cmpq $%[1]d, %%r12
+	je flush
+	ret
+
+# flush writes the output buffer to stdout.
+flush:
+	leaq outbuf(%%rip), %%rsi
+	testq %%r12, %%r12
+	jz 2f
+1:
+	movl $1, %%eax
+	movl $1, %%edi
+	movq %%r12, %%rdx
+	syscall
+	testq %%rax, %%rax
+	jle fail
+	addq %%rax, %%rsi
+	subq %%rax, %%r12
+	jnz 1b
+2:
+	ret
+
+# getc returns the next byte of input in %%eax, or -1 on EOF. Pending output
+# is flushed before reading more input.
+getc:
+	cmpq %%r14, %%r13
+	jb 1f
+	call flush
+	xorl %%eax, %%eax
+	xorl %%edi, %%edi
+	leaq inbuf(%%rip), %%rsi
+	movl $%[1]d, %%edx
+	syscall
+	testq %%rax, %%rax
+	js fail
+	jz 2f
+	movq %%rax, %%r14
+	xorl %%r13d, %%r13d
+1:
+	leaq inbuf(%%rip), %%rsi
+	movzbl (%%rsi,%%r13), %%eax
+	incq %%r13
+	ret
+2:
+	movl $-1, %%eax
+	ret
+
+# fail exits with status 1 if a system call fails.
+fail:
+	movl $60, %%eax
+	movl $1, %%edi
+	syscall
+`, bufferSize)
+
+// writer writes lines of assembly.
+type writer struct {
+	dst bytes.Buffer
+}
+
+// op writes an indented line, like an instruction or a directive.
+func (w *writer) op(format string, a ...interface{}) {
+	w.dst.WriteByte('\t')
+	w.raw(format, a...)
+}
+
+// raw writes a line without indentation, like a label.
+func (w *writer) raw(format string, a ...interface{}) {
+	fmt.Fprintf(&w.dst, format, a...)
+	w.dst.WriteByte('\n')
+}
+
+// move writes code which moves the pointer by the given offset.
+func (w *writer) move(offset int) {
+	if offset != 0 {
+		w.op("addq $%d, %%rbx", offset)
+	}
+}
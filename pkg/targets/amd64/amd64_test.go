@@ -0,0 +1,71 @@
+package amd64_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"laptudirm.com/x/brainfuck/pkg/lexer"
+	"laptudirm.com/x/brainfuck/pkg/parser"
+	"laptudirm.com/x/brainfuck/pkg/targets/amd64"
+	"laptudirm.com/x/brainfuck/pkg/targets/internal/targettest"
+)
+
+func TestCompile(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("not running on linux/amd64")
+	}
+
+	as, err := exec.LookPath("as")
+	if err != nil {
+		t.Skip("no assembler found")
+	}
+
+	ld, err := exec.LookPath("ld")
+	if err != nil {
+		t.Skip("no linker found")
+	}
+
+	dir := t.TempDir()
+	for n, test := range targettest.Tests {
+		chunk, err := parser.Parse(lexer.Lex([]byte(test.Src)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		src := filepath.Join(dir, "prog.s")
+		obj := filepath.Join(dir, "prog.o")
+		bin := filepath.Join(dir, "prog")
+		code := amd64.Compile(chunk, amd64.Options{EOF: test.EOF})
+		if err := os.WriteFile(src, code, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if out, err := exec.Command(as, "-o", obj, src).CombinedOutput(); err != nil {
+			t.Fatalf("%d: %v\n%s\n%s", n, err, out, code)
+		}
+
+		if out, err := exec.Command(ld, "-o", bin, obj).CombinedOutput(); err != nil {
+			t.Fatalf("%d: %v\n%s", n, err, out)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		cmd := exec.CommandContext(ctx, bin)
+		cmd.Stdin = strings.NewReader(test.Input)
+		output, err := cmd.Output()
+		cancel()
+		if err != nil {
+			t.Fatalf("%d: %v", n, err)
+		}
+
+		if !bytes.Equal(output, []byte(test.Output)) {
+			t.Errorf("%d: expected output %q, received %q", n, test.Output, output)
+		}
+	}
+}
@@ -0,0 +1,484 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package brainfuck implements the brainfuck compilation target, which
+// compiles an instruction.Chunk back into brainfuck source code. Combined
+// with the optimizer, it can be used to minify and normalize programs.
+//
+// Instructions are lowered into the usual idioms, like [-] for Set and
+// [->++<] for Mul. Print needs an empty cell to build the printed byte in,
+// and so does Mul if it's source cell is read afterwards, since the source
+// is cleared by the loop and has to be restored. If a chunk needs such
+// scratch cells, the cell at each offset is stored at twice the offset in
+// the compiled program, and the cell after each cell is used as it's
+// scratch cell, which is always cleared before a loop or an if. The
+// compiled program then uses twice as many cells, but it never moves the
+// pointer to the left of the cells used by the chunk, and it's output is
+// the same.
+//
+// The compiled program leaves a cell unchanged on EOF only if it is run by
+// an interpreter which does the same.
+package brainfuck
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+)
+
+// Compile compiles an instruction.Chunk into brainfuck source code, which
+// has no characters other than the eight commands.
+func Compile(c *instruction.Chunk) []byte {
+	instruction.VerifyDebug(c)
+
+	ins := make([]instruction.Instruction, c.Len())
+	for i := range ins {
+		ins[i] = c.Instruction(i)
+	}
+
+	// try without scratch cells first
+	cc := compiler{stride: 1}
+	if !cc.compile(ins) {
+		cc = compiler{stride: 2}
+		cc.compile(ins)
+	}
+
+	return cc.dst
+}
+
+// compiler stores the state of a chunk being compiled into brainfuck.
+type compiler struct {
+	dst []byte // result
+
+	// stride is the distance between the cells of the chunk in the
+	// compiled program, which is 2 if scratch cells are used
+	stride int
+
+	// pointer is the position of the pointer, and known are the known
+	// values of cells, relative to the current frame in the compiled
+	// program. Scratch cells which aren't in known are zero.
+	pointer int
+	known   map[int]byte
+
+	// start is set until the first loop or if, since until then every
+	// cell which isn't in known or unknown is zero
+	start   bool
+	unknown map[int]bool
+
+	// noScratch is set if scratch cells are needed but stride is 1
+	noScratch bool
+}
+
+// compile compiles the given instructions, returning false if scratch
+// cells are needed but not available.
+func (c *compiler) compile(ins []instruction.Instruction) bool {
+	c.reset(make(map[int]byte))
+	c.start = true
+	for i := 0; i < len(ins) && !c.noScratch; {
+		i = c.instruction(ins, i)
+	}
+
+	return !c.noScratch
+}
+
+// instruction compiles the instruction at index i, along with any
+// instructions after it which are compiled together, and returns the
+// index of the next instruction.
+func (c *compiler) instruction(ins []instruction.Instruction, i int) int {
+	switch v := ins[i].(type) {
+	case instruction.Value, instruction.Set:
+		end := i
+		for end < len(ins) && isWrite(ins[end]) {
+			end++
+		}
+
+		c.writes(ins[i:end])
+		return end
+
+	case instruction.Mul:
+		if v.Offset == v.Source {
+			c.selfMul(v, isDead(ins[i+1:], v.Source))
+			return i + 1
+		}
+
+		// Muls with the same source are compiled into a single loop
+		end := i + 1
+		for ; end < len(ins); end++ {
+			m, ok := ins[end].(instruction.Mul)
+			if !ok || m.Source != v.Source || m.Offset == m.Source {
+				break
+			}
+		}
+
+		c.mul(v.Source, ins[i:end], isDead(ins[end:], v.Source))
+		return end
+
+	case instruction.Input:
+		c.move(c.cell(v.Offset))
+		c.emit(',')
+		c.forget(c.pointer)
+
+	case instruction.Output:
+		c.move(c.cell(v.Offset))
+		c.emit('.')
+
+	case instruction.Print:
+		c.print(v.X)
+
+	case instruction.StartLoop:
+		c.clearScratch()
+		c.move(c.cell(v.Offset))
+		c.emit('[')
+
+		c.reset(make(map[int]byte))
+		if !v.Balanced {
+			// the frame is moved to the pointer
+			c.pointer = 0
+		}
+
+	case instruction.EndLoop:
+		c.clearScratch()
+		c.move(c.cell(v.Offset))
+		c.emit(']')
+
+		if !v.Balanced {
+			// the frame is moved to the pointer
+			c.pointer = 0
+		}
+
+		// the loop only exits if the cell is zero
+		c.reset(map[int]byte{c.pointer: 0})
+
+	case instruction.If:
+		c.clearScratch()
+		c.move(c.cell(v.Offset))
+		c.emit('[')
+		c.reset(make(map[int]byte))
+
+	case instruction.EndIf:
+		// the cell is zero at the end of an if, so the loop exits
+		c.clearScratch()
+		c.move(c.cell(v.Offset))
+		c.emit(']')
+		c.reset(map[int]byte{c.pointer: 0})
+
+	default:
+		// unreachable
+		t := reflect.ValueOf(v).Type() // get instruction type
+		panic(fmt.Sprintf("brainfuck: compile: invalid instruction type %s in chunk", t))
+	}
+
+	return i + 1
+}
+
+// writes compiles the given Value and Set instructions, which are reordered
+// to reduce pointer movement, keeping the order of writes to the same cell.
+func (c *compiler) writes(ins []instruction.Instruction) {
+	ins = append([]instruction.Instruction(nil), ins...)
+
+	// start from the end closest to the pointer
+	sort.SliceStable(ins, func(i, j int) bool {
+		return ins[i].MemOffset() < ins[j].MemOffset()
+	})
+
+	first, last := c.cell(ins[0].MemOffset()), c.cell(ins[len(ins)-1].MemOffset())
+	if abs(last-c.pointer) < abs(first-c.pointer) {
+		sort.SliceStable(ins, func(i, j int) bool {
+			return ins[i].MemOffset() > ins[j].MemOffset()
+		})
+	}
+
+	for _, i := range ins {
+		switch v := i.(type) {
+		case instruction.Value:
+			c.move(c.cell(v.Offset))
+			c.change(v.X)
+			if x, ok := c.value(c.pointer); ok {
+				c.known[c.pointer] = x + v.X
+			}
+
+		case instruction.Set:
+			c.set(c.cell(v.Offset), v.X)
+		}
+	}
+}
+
+// mul compiles the given Muls, which have the given source and different
+// destinations, into a loop which clears the source. The source is
+// restored using a scratch cell unless it is dead afterwards.
+func (c *compiler) mul(source int, muls []instruction.Instruction, dead bool) {
+	s := c.cell(source)
+
+	amounts := make(map[int]byte)
+	for _, i := range muls {
+		m := i.(instruction.Mul)
+		amounts[c.cell(m.Offset)] += m.X
+	}
+
+	var cells []int
+	for cell, x := range amounts {
+		if x != 0 {
+			cells = append(cells, cell)
+		}
+	}
+
+	if len(cells) == 0 {
+		// the destinations don't change, and the source needn't be cleared
+		return
+	}
+
+	restore := !dead
+	if restore {
+		// copy the source into the scratch cell after it
+		cells = append(cells, c.scratch(s+1))
+		amounts[s+1] = 1
+	}
+
+	sort.Ints(cells)
+
+	c.move(s)
+	c.emit('[', '-')
+	for _, cell := range cells {
+		c.move(cell)
+		c.change(amounts[cell])
+		c.forget(cell)
+	}
+	c.move(s)
+	c.emit(']')
+	c.known[s] = 0
+
+	if restore {
+		c.move(s + 1)
+		c.emit('[', '-')
+		c.move(s)
+		c.emit('+')
+		c.move(s + 1)
+		c.emit(']')
+		c.forget(s)
+		c.known[s+1] = 0
+	}
+}
+
+// selfMul compiles a Mul whose source is it's destination, which is done
+// by moving the cell into a scratch cell and adding it back multiplied.
+// Nothing is compiled if the cell is dead afterwards.
+func (c *compiler) selfMul(m instruction.Mul, dead bool) {
+	if dead {
+		return
+	}
+
+	s := c.cell(m.Source)
+	t := c.scratch(s + 1)
+
+	c.move(s)
+	c.emit('[', '-')
+	c.move(t)
+	c.emit('+')
+	c.move(s)
+	c.emit(']')
+
+	c.move(t)
+	c.emit('[', '-')
+	c.move(s)
+	c.change(m.X + 1)
+	c.move(t)
+	c.emit(']')
+
+	c.forget(s)
+	c.known[t] = 0
+}
+
+// print compiles a Print, which builds the byte in the scratch cell under
+// or after the pointer, and writes it.
+func (c *compiler) print(x byte) {
+	cell := c.pointer | 1
+	c.move(c.scratch(cell))
+	c.change(x - c.known[cell])
+	c.emit('.')
+	c.known[cell] = x
+}
+
+// set sets the given cell to x, clearing it first unless it's value is
+// known and changing it is shorter.
+func (c *compiler) set(cell int, x byte) {
+	c.move(cell)
+	if v, ok := c.value(cell); ok && changeCost(x-v) <= 3+changeCost(x) {
+		c.change(x - v)
+	} else {
+		c.emit('[', '-', ']')
+		c.change(x)
+	}
+
+	c.known[cell] = x
+}
+
+// clearScratch clears all the scratch cells which are not zero.
+func (c *compiler) clearScratch() {
+	var cells []int
+	for cell, x := range c.known {
+		if c.stride == 2 && cell&1 == 1 && x != 0 {
+			cells = append(cells, cell)
+		}
+	}
+
+	if len(cells) == 0 {
+		return
+	}
+
+	// start from the end closest to the pointer
+	sort.Ints(cells)
+	if abs(cells[len(cells)-1]-c.pointer) < abs(cells[0]-c.pointer) {
+		sort.Sort(sort.Reverse(sort.IntSlice(cells)))
+	}
+
+	for _, cell := range cells {
+		c.set(cell, 0)
+	}
+}
+
+// value returns the value of the given cell, and whether it is known.
+func (c *compiler) value(cell int) (byte, bool) {
+	if x, ok := c.known[cell]; ok {
+		return x, true
+	}
+
+	// scratch cells are zero unless they are known
+	if c.stride == 2 && cell&1 == 1 {
+		return 0, true
+	}
+
+	return 0, c.start && !c.unknown[cell]
+}
+
+// forget records that the value of the given cell is unknown.
+func (c *compiler) forget(cell int) {
+	delete(c.known, cell)
+	c.unknown[cell] = true
+}
+
+// reset replaces the known values of cells with the given ones, at the
+// start or end of a loop or an if.
+func (c *compiler) reset(known map[int]byte) {
+	c.known = known
+	c.start = false
+	c.unknown = make(map[int]bool)
+}
+
+// cell returns the position of the cell at the given offset in the
+// compiled program.
+func (c *compiler) cell(offset int) int {
+	return offset * c.stride
+}
+
+// scratch returns the given scratch cell, recording that scratch cells are
+// needed if they are not available.
+func (c *compiler) scratch(cell int) int {
+	if c.stride == 1 {
+		c.noScratch = true
+	}
+
+	return cell
+}
+
+// move moves the pointer to the given cell.
+func (c *compiler) move(cell int) {
+	for ; c.pointer < cell; c.pointer++ {
+		c.emit('>')
+	}
+
+	for ; c.pointer > cell; c.pointer-- {
+		c.emit('<')
+	}
+}
+
+// change changes the cell under the pointer by x, in the shorter direction.
+func (c *compiler) change(x byte) {
+	if x <= 128 {
+		for ; x > 0; x-- {
+			c.emit('+')
+		}
+		return
+	}
+
+	for ; x != 0; x++ {
+		c.emit('-')
+	}
+}
+
+// emit appends the given commands to the compiled program.
+func (c *compiler) emit(commands ...byte) {
+	c.dst = append(c.dst, commands...)
+}
+
+// changeCost returns the number of commands needed to change a cell by x.
+func changeCost(x byte) int {
+	if x <= 128 {
+		return int(x)
+	}
+
+	return 256 - int(x)
+}
+
+// isDead checks if the given cell is written before it is read in the
+// given instructions, or if it is never read again, so that it's value
+// doesn't matter. Loops and ifs are conservatively considered reads.
+func isDead(ins []instruction.Instruction, offset int) bool {
+	for _, i := range ins {
+		switch v := i.(type) {
+		case instruction.Set:
+			if v.Offset == offset {
+				return true
+			}
+
+		case instruction.Print:
+			// doesn't access memory
+
+		case instruction.Mul:
+			if v.Source == offset || v.Offset == offset {
+				return false
+			}
+
+		case instruction.Value, instruction.Input, instruction.Output:
+			if v.MemOffset() == offset {
+				return false
+			}
+
+		default:
+			return false
+		}
+	}
+
+	// the cell is never read again
+	return true
+}
+
+// isWrite checks if the given instruction is a Value or a Set.
+func isWrite(i instruction.Instruction) bool {
+	switch i.(type) {
+	case instruction.Value, instruction.Set:
+		return true
+	default:
+		return false
+	}
+}
+
+// abs returns the absolute value of x.
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+
+	return x
+}
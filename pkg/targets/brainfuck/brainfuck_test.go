@@ -0,0 +1,96 @@
+package brainfuck_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+	"laptudirm.com/x/brainfuck/pkg/lexer"
+	"laptudirm.com/x/brainfuck/pkg/parser"
+	"laptudirm.com/x/brainfuck/pkg/reference"
+	"laptudirm.com/x/brainfuck/pkg/targets/brainfuck"
+	"laptudirm.com/x/brainfuck/pkg/targets/internal/targettest"
+)
+
+var compileTests = []struct {
+	src      string // textual IR
+	expected string
+}{
+	{src: "Value(3)@0\nValue(-2)@-1", expected: "+++<--"},
+	{src: "Input@0\nSet(1)@2\nSet(254)@0", expected: ",[-]-->>+"},
+	{src: "Input@0\nMul(2, 0)@1\nMul(-1, 0)@-1", expected: ",[-<->>++<]"},
+	{src: "Input@0\nStartLoop@0\nOutput@0\nEndLoop@1", expected: ",[.>]"},
+	{src: "Input@0\nIf@0\nOutput@1\nSet(0)@0\nEndIf@0", expected: ",[>.<[-]]"},
+
+	// the source is read afterwards, so scratch cells are used
+	{src: "Input@0\nMul(1, 0)@1\nOutput@0", expected: ",[->+>+<<]>[-<+>]<."},
+	{src: "Print(72)\nPrint(73)", expected: ">" + strings.Repeat("+", 72) + ".+."},
+}
+
+func TestCompile(t *testing.T) {
+	for _, test := range compileTests {
+		chunk, err := instruction.ParseText([]byte(test.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if src := string(brainfuck.Compile(chunk)); src != test.expected {
+			t.Errorf("%q: expected %s, received %s", test.src, test.expected, src)
+		}
+	}
+}
+
+// tape is the size of the memory tape of the original programs while
+// fuzzing. The pointer starts in the middle of the tape.
+const tape = 256
+
+// FuzzCompile checks that the output of programs which are optimized and
+// compiled back into brainfuck is the same as that of the originals.
+func FuzzCompile(f *testing.F) {
+	f.Add(targettest.Data("++++++++[>++++[>++>+++>+++>+<<<<-]>+>+>->>+[<]<-]>>.>---.+++++++..+++.>>.<-.<.+++.------.--------.>>+.>++."), []byte(""))
+	f.Add(targettest.Data(",[->++<]>."), []byte("A"))
+	f.Add(targettest.Data(",[>+.<[-]]+[->+.<]>."), []byte("x"))
+	f.Add(targettest.Data(",[->+>+<<]>[-<+>]<<,."), []byte("ab"))
+
+	f.Fuzz(func(t *testing.T, data, input []byte) {
+		src := targettest.Program(data)
+		expected, ok := interpret(src, input, tape)
+		if !ok {
+			// out of bounds or too slow, nothing to compare
+			t.Skip()
+		}
+
+		chunk, err := parser.Parse(lexer.Lex(src))
+		if err != nil {
+			t.Fatalf("%s: %v", src, err)
+		}
+
+		// compiled programs may use twice the cells
+		compiled := brainfuck.Compile(chunk)
+		output, ok := interpret(compiled, input, 2*tape)
+		if !ok {
+			t.Fatalf("%s: compiled program %s failed", src, compiled)
+		}
+
+		if !bytes.Equal(output, expected) {
+			t.Errorf("%s: expected output %q, received %q from %s", src, expected, output, compiled)
+		}
+	})
+}
+
+// interpret runs the given program on the reference interpreter with a
+// tape of the given size, returning it's output and whether it succeeded.
+func interpret(src, input []byte, size int) ([]byte, bool) {
+	var output bytes.Buffer
+	ref := reference.Interpreter{
+		Memory:   make([]byte, size),
+		Pointer:  size / 2,
+		Input:    bytes.NewReader(input),
+		Output:   &output,
+		MaxSteps: 100000 * size / tape,
+	}
+
+	err := ref.Run(lexer.Lex(src))
+	return output.Bytes(), err == nil
+}
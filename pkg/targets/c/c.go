@@ -0,0 +1,226 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package c implements the C compilation target, which compiles an
+// instruction.Chunk into a portable C program that uses only the standard
+// library. The program can be compiled with any C compiler, like:
+//
+//	brainfuck build --target=c -o prog.c prog.bf
+//	cc -O2 -o prog prog.c
+//
+// The memory tape is a static array, and the pointer starts at it's first
+// cell. Cells are accessed using the pointer and the offset of the cell,
+// and the pointer is not bounds checked.
+package c
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+	"laptudirm.com/x/brainfuck/pkg/targets"
+)
+
+// Options configures the compiled program.
+type Options struct {
+	TapeSize int         // number of cells, or 0 for targets.DefaultTapeSize
+	EOF      targets.EOF // what input stores in a cell on EOF
+
+	// Filename is the name of the brainfuck source file, which is used in
+	// #line directives pointing back to the source of every instruction.
+	// The directives are omitted if it is empty.
+	Filename string
+}
+
+// Compile compiles an instruction.Chunk into the source code of a C
+// program.
+func Compile(c *instruction.Chunk, o Options) []byte {
+	instruction.VerifyDebug(c)
+
+	tape := o.TapeSize
+	if tape == 0 {
+		tape = targets.DefaultTapeSize
+	}
+
+	w := writer{filename: o.Filename, depth: 1}
+	fmt.Fprintf(&w.dst, "// Code generated by brainfuck. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&w.dst, "#include <stdio.h>\n\n")
+	fmt.Fprintf(&w.dst, "static unsigned char tape[%d];\n\n", tape)
+	fmt.Fprintf(&w.dst, "int main(void) {\n")
+	fmt.Fprintf(&w.dst, "\tunsigned char *p = tape;\n")
+	if readsInput(c) && o.EOF != targets.EOFMinusOne {
+		fmt.Fprintf(&w.dst, "\tint c;\n")
+	}
+	fmt.Fprintf(&w.dst, "\n")
+
+	length := c.Len()
+	for i := 0; i < length; i++ {
+		ins := c.Instruction(i)
+		w.position(ins)
+
+		switch v := ins.(type) {
+		case instruction.Value:
+			if x := int8(v.X); x < 0 {
+				w.line("p[%d] -= %d;", v.Offset, -int(x))
+			} else {
+				w.line("p[%d] += %d;", v.Offset, x)
+			}
+
+		case instruction.Set:
+			w.line("p[%d] = %d;", v.Offset, v.X)
+
+		case instruction.Mul:
+			// the destination must not be accessed if the source is zero
+			w.line("if (p[%d]) p[%d] += p[%d] * %d;", v.Source, v.Offset, v.Source, int8(v.X))
+
+		case instruction.Input:
+			// flush any pending output, like prompts, before blocking
+			w.line("fflush(stdout);")
+
+			switch o.EOF {
+			case targets.EOFUnchanged:
+				w.line("if ((c = getchar()) != EOF) p[%d] = c;", v.Offset)
+			case targets.EOFZero:
+				w.line("p[%d] = (c = getchar()) == EOF ? 0 : c;", v.Offset)
+			case targets.EOFMinusOne:
+				// EOF is negative, and is converted to 255
+				w.line("p[%d] = getchar();", v.Offset)
+			}
+
+		case instruction.Output:
+			w.line("putchar(p[%d]);", v.Offset)
+
+		case instruction.Print:
+			w.line("putchar(%s);", char(v.X))
+
+		case instruction.StartLoop:
+			if v.Balanced {
+				w.line("while (p[%d]) {", v.Offset)
+			} else {
+				// the pointer is moved before the loop is entered, and at
+				// the end of every iteration
+				w.move(v.Offset)
+				w.line("while (*p) {")
+			}
+
+			w.depth++
+
+		case instruction.EndLoop:
+			if !v.Balanced {
+				w.move(v.Offset)
+			}
+
+			w.depth--
+			w.line("}")
+
+		case instruction.If:
+			w.line("if (p[%d]) {", v.Offset)
+			w.depth++
+
+		case instruction.EndIf:
+			w.depth--
+			w.line("}")
+
+		default:
+			// unreachable
+			t := reflect.ValueOf(ins).Type() // get instruction type
+			panic(fmt.Sprintf("c: compile: invalid instruction type %s in chunk", t))
+		}
+	}
+
+	fmt.Fprintf(&w.dst, "\n\treturn 0;\n}\n")
+	return w.dst.Bytes()
+}
+
+// writer writes indented lines of C code.
+type writer struct {
+	dst   bytes.Buffer
+	depth int // indentation depth
+
+	filename string // source file name for #line directives
+	source   int    // source line of the current instruction, 0 if unknown
+
+	// next is the source line the compiler assumes for the next line of
+	// code, which is incremented after every line, or 0 before the first
+	// #line directive
+	next int
+}
+
+// line writes a line of code at the current depth, after a #line directive
+// if the compiler doesn't assume the source line of the current instruction.
+func (w *writer) line(format string, a ...interface{}) {
+	if w.filename != "" && w.source != 0 && w.source != w.next {
+		w.next = w.source
+		fmt.Fprintf(&w.dst, "#line %d \"%s\"\n", w.next, escape(w.filename))
+	}
+
+	w.dst.WriteString(strings.Repeat("\t", w.depth))
+	fmt.Fprintf(&w.dst, format, a...)
+	w.dst.WriteByte('\n')
+
+	if w.next > 0 {
+		w.next++
+	}
+}
+
+// move writes code which moves the pointer by the given offset.
+func (w *writer) move(offset int) {
+	switch {
+	case offset > 0:
+		w.line("p += %d;", offset)
+	case offset < 0:
+		w.line("p -= %d;", -offset)
+	}
+}
+
+// position sets the source line of the lines of code written for the given
+// instruction.
+func (w *writer) position(i instruction.Instruction) {
+	w.source = 0
+	if spans := i.SourceSpans(); len(spans) > 0 {
+		w.source = spans[0].Start.Line
+	}
+}
+
+// readsInput checks if the given chunk has any Input instructions.
+func readsInput(c *instruction.Chunk) bool {
+	for i := 0; i < c.Len(); i++ {
+		if _, ok := c.Instruction(i).(instruction.Input); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// char returns a C character constant for the given byte, or it's value if
+// it isn't a printable character.
+func char(x byte) string {
+	switch {
+	case x == '\'' || x == '\\':
+		return fmt.Sprintf("'\\%c'", x)
+	case x == '\n':
+		return `'\n'`
+	case x >= ' ' && x <= '~':
+		return fmt.Sprintf("'%c'", x)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// escape escapes the given string for use in a C string literal.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
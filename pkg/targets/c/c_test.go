@@ -0,0 +1,147 @@
+package c_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+	"laptudirm.com/x/brainfuck/pkg/lexer"
+	"laptudirm.com/x/brainfuck/pkg/parser"
+	"laptudirm.com/x/brainfuck/pkg/reference"
+	"laptudirm.com/x/brainfuck/pkg/targets/c"
+	"laptudirm.com/x/brainfuck/pkg/targets/internal/targettest"
+)
+
+func TestCompile(t *testing.T) {
+	cc := compiler(t)
+	dir := t.TempDir()
+	for n, test := range targettest.Tests {
+		output, code := run(t, cc, dir, []byte(test.Src), []byte(test.Input), c.Options{EOF: test.EOF, Filename: "prog.bf"})
+		if !bytes.Equal(output, []byte(test.Output)) {
+			t.Errorf("%d: expected output %q, received %q\n%s", n, test.Output, output, code)
+		}
+	}
+}
+
+func TestLines(t *testing.T) {
+	chunk, err := instruction.ParseText([]byte("Input@0 ; 1:1\nOutput@0 ; 1:2\nPrint(65) ; 1:3\nOutput@1 ; 3:1\nValue(1)@1 ; 4:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lines of the source which each statement should be reported at
+	expected := map[string]int{
+		"fflush(stdout);":                       1,
+		"if ((c = getchar()) != EOF) p[0] = c;": 1,
+		"putchar(p[0]);":                        1,
+		"putchar('A');":                         1,
+		"putchar(p[1]);":                        3,
+		"p[1] += 1;":                            4,
+	}
+
+	// number the lines like the C compiler does after #line directives
+	code := c.Compile(chunk, c.Options{Filename: "prog.bf"})
+	line := 0
+	for _, text := range strings.Split(string(code), "\n") {
+		text = strings.TrimSpace(text)
+		if _, err := fmt.Sscanf(text, "#line %d", &line); err == nil {
+			continue
+		}
+
+		if n, ok := expected[text]; ok && n != line {
+			t.Errorf("%s: expected line %d, received %d\n%s", text, n, line, code)
+		}
+
+		if line > 0 {
+			line++
+		}
+	}
+}
+
+// tape is the size of the memory tape while fuzzing.
+const tape = 256
+
+// FuzzCompile checks that the output of programs which are optimized and
+// compiled into C is the same as that of the reference interpreter.
+func FuzzCompile(f *testing.F) {
+	f.Add(targettest.Data("++++++++[>++++[>++>+++>+++>+<<<<-]>+>+>->>+[<]<-]>>.>---.+++++++..+++.>>.<-.<.+++.------.--------.>>+.>++."), []byte(""))
+	f.Add(targettest.Data(",[->++<]>."), []byte("A"))
+	f.Add(targettest.Data(",[>+.<[-]]+[->+.<]>."), []byte("x"))
+	f.Add(targettest.Data(">,[->+>+<<]>[-<+>]<<,."), []byte("ab"))
+
+	f.Fuzz(func(t *testing.T, data, input []byte) {
+		src := targettest.Program(data)
+
+		// the pointer of compiled programs starts at the first cell, and
+		// isn't bounds checked, so only programs which stay in bounds on
+		// the reference interpreter are compared
+		var expected bytes.Buffer
+		ref := reference.Interpreter{
+			Memory:   make([]byte, tape),
+			Input:    bytes.NewReader(input),
+			Output:   &expected,
+			MaxSteps: 100000,
+		}
+
+		if err := ref.Run(lexer.Lex(src)); err != nil {
+			// out of bounds or too slow, nothing to compare
+			t.Skip()
+		}
+
+		output, code := run(t, compiler(t), t.TempDir(), src, input, c.Options{TapeSize: tape})
+		if !bytes.Equal(output, expected.Bytes()) {
+			t.Errorf("%s: expected output %q, received %q\n%s", src, expected.Bytes(), output, code)
+		}
+	})
+}
+
+// compiler returns the path of the C compiler, and skips the test if there
+// is none.
+func compiler(t *testing.T) string {
+	cc, err := exec.LookPath("cc")
+	if err != nil {
+		t.Skip("no C compiler found")
+	}
+
+	return cc
+}
+
+// run compiles the given program into C in the given directory, builds it
+// with the C compiler, and runs it with the given input. It returns the
+// output of the program and the generated code.
+func run(t *testing.T, cc, dir string, src, input []byte, o c.Options) ([]byte, []byte) {
+	chunk, err := parser.Parse(lexer.Lex(src))
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+
+	file := filepath.Join(dir, "prog.c")
+	bin := filepath.Join(dir, "prog")
+	code := c.Compile(chunk, o)
+	if err := os.WriteFile(file, code, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if out, err := exec.Command(cc, "-o", bin, file).CombinedOutput(); err != nil {
+		t.Fatalf("%s: %v\n%s\n%s", src, err, out, code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, bin)
+	cmd.Stdin = bytes.NewReader(input)
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s: %v\n%s", src, err, code)
+	}
+
+	return output, code
+}
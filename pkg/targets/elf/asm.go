@@ -0,0 +1,196 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package elf
+
+import "encoding/binary"
+
+// label represents a position in machine code, which may not be known yet.
+type label int
+
+// fixup is a rel32 operand which is patched once it's label is known.
+type fixup struct {
+	at    int // position of the operand
+	label label
+}
+
+// address represents the address of a part of the memory of a compiled
+// program, which is only known once all the code has been compiled.
+type address int
+
+// Parts of the memory, in the order they are laid out.
+const (
+	tapeAddress address = iota
+	outbufAddress
+	inbufAddress
+)
+
+// addressFixup is an imm32 operand which is patched with an address.
+type addressFixup struct {
+	at      int // position of the operand
+	address address
+}
+
+// offset returns the offset of the address from the start of the memory,
+// for the given tape size.
+func (f addressFixup) offset(tape int) uint64 {
+	switch f.address {
+	case tapeAddress:
+		return 0
+	case outbufAddress:
+		return uint64(tape)
+	default:
+		return uint64(tape) + bufferSize
+	}
+}
+
+// assembler encodes x86-64 machine code. Only the few instructions used by
+// compiled programs are supported, and their operands are encoded by hand.
+// Cells are always addressed relative to %rbx, the memory pointer.
+type assembler struct {
+	code []byte
+
+	labels []int // positions of labels, -1 if unknown
+	fixups []fixup
+
+	addresses []addressFixup
+}
+
+// Opcodes of instructions which are followed by a rel32 operand.
+var (
+	call = []byte{0xe8}
+	je   = []byte{0x0f, 0x84}
+	jne  = []byte{0x0f, 0x85}
+	jb   = []byte{0x0f, 0x82}
+	js   = []byte{0x0f, 0x88}
+	jle  = []byte{0x0f, 0x8e}
+)
+
+// emit appends the given bytes to the machine code.
+func (a *assembler) emit(b ...byte) {
+	a.code = append(a.code, b...)
+}
+
+// imm32 appends a 32-bit immediate.
+func (a *assembler) imm32(x uint32) {
+	a.emit(byte(x), byte(x>>8), byte(x>>16), byte(x>>24))
+}
+
+// address appends an imm32 operand which is patched with the given
+// address.
+func (a *assembler) address(addr address) {
+	a.addresses = append(a.addresses, addressFixup{at: len(a.code), address: addr})
+	a.imm32(0)
+}
+
+// newLabel returns a new label, whose position is unknown.
+func (a *assembler) newLabel() label {
+	a.labels = append(a.labels, -1)
+	return label(len(a.labels) - 1)
+}
+
+// bind sets the position of the given label to the current position.
+func (a *assembler) bind(l label) {
+	a.labels[l] = len(a.code)
+}
+
+// jump appends an instruction with the given opcode and a rel32 operand
+// pointing to the given label.
+func (a *assembler) jump(op []byte, l label) {
+	a.emit(op...)
+	a.fixups = append(a.fixups, fixup{at: len(a.code), label: l})
+	a.imm32(0)
+}
+
+// link patches the operands pointing to labels. All the labels must have
+// been bound.
+func (a *assembler) link() {
+	for _, f := range a.fixups {
+		// relative to the end of the operand
+		rel := a.labels[f.label] - (f.at + 4)
+		binary.LittleEndian.PutUint32(a.code[f.at:], uint32(int32(rel)))
+	}
+}
+
+// mem appends a ModRM byte addressing the cell at the given offset, along
+// with it's displacement, using the given reg field.
+func (a *assembler) mem(reg byte, offset int) {
+	const rbx = 0b011
+
+	switch {
+	case offset == 0:
+		a.emit(0b00<<6 | reg<<3 | rbx)
+	case offset >= -128 && offset <= 127:
+		a.emit(0b01<<6|reg<<3|rbx, byte(int8(offset)))
+	default:
+		a.emit(0b10<<6 | reg<<3 | rbx)
+		a.imm32(uint32(int32(offset)))
+	}
+}
+
+// memLen returns the number of bytes appended by mem for the given offset.
+func memLen(offset int) int {
+	switch {
+	case offset == 0:
+		return 1
+	case offset >= -128 && offset <= 127:
+		return 2
+	default:
+		return 5
+	}
+}
+
+// addCell appends addb $x, offset(%rbx).
+func (a *assembler) addCell(offset int, x byte) {
+	a.emit(0x80)
+	a.mem(0, offset)
+	a.emit(x)
+}
+
+// setCell appends movb $x, offset(%rbx).
+func (a *assembler) setCell(offset int, x byte) {
+	a.emit(0xc6)
+	a.mem(0, offset)
+	a.emit(x)
+}
+
+// testCell appends cmpb $0, offset(%rbx).
+func (a *assembler) testCell(offset int) {
+	a.emit(0x80)
+	a.mem(7, offset)
+	a.emit(0)
+}
+
+// loadCell appends movb offset(%rbx), %al.
+func (a *assembler) loadCell(offset int) {
+	a.emit(0x8a)
+	a.mem(0, offset)
+}
+
+// storeCell appends movb %al, offset(%rbx).
+func (a *assembler) storeCell(offset int) {
+	a.emit(0x88)
+	a.mem(0, offset)
+}
+
+// move appends addq $offset, %rbx, unless offset is 0.
+func (a *assembler) move(offset int) {
+	switch {
+	case offset == 0:
+	case offset >= -128 && offset <= 127:
+		a.emit(0x48, 0x83, 0xc3, byte(int8(offset)))
+	default:
+		a.emit(0x48, 0x81, 0xc3)
+		a.imm32(uint32(int32(offset)))
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package elf implements the ELF compilation target, which compiles an
+// instruction.Chunk directly into a static ELF64 executable for x86-64
+// Linux, without an assembler, a linker, or libc. The machine code is the
+// same as that of the amd64 target: the memory pointer is stored in %rbx,
+// and input and output are buffered, and use the read and write system
+// calls. The pointer is not bounds checked.
+//
+// The executable has two segments, one with the headers and the machine
+// code, and one with the memory tape and the buffers, which is not stored
+// in the file.
+package elf
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+	"laptudirm.com/x/brainfuck/pkg/targets"
+)
+
+// Options configures the compiled program.
+type Options struct {
+	TapeSize int         // number of cells, or 0 for targets.DefaultTapeSize
+	EOF      targets.EOF // what input stores in a cell on EOF
+}
+
+// MaxTapeSize is the maximum number of cells of a compiled program, so that
+// every address fits in 32 bits.
+const MaxTapeSize = 1 << 30
+
+// Memory layout of compiled programs.
+const (
+	base       = 0x400000 // address of the first segment
+	pageSize   = 0x1000
+	bufferSize = 4096 // size of the input and output buffers
+
+	headerSize = 64 + 2*56 // size of the file and program headers
+)
+
+// Compile compiles an instruction.Chunk into a static ELF64 executable for
+// x86-64 Linux. It panics if the tape size is larger than MaxTapeSize.
+func Compile(c *instruction.Chunk, o Options) []byte {
+	instruction.VerifyDebug(c)
+
+	tape := o.TapeSize
+	if tape == 0 {
+		tape = targets.DefaultTapeSize
+	}
+
+	if tape < 0 || tape > MaxTapeSize {
+		panic(fmt.Sprintf("elf: compile: invalid tape size %d", tape))
+	}
+
+	// the code is followed by the memory, leaving a page unmapped between
+	// them, so that moving the pointer to the left of the tape faults
+	var a assembler
+	code := compile(&a, c, o.EOF)
+	memory := alignUp(base+headerSize+uint64(len(code)), pageSize) + pageSize
+	outbuf := memory + uint64(tape)
+	inbuf := outbuf + bufferSize
+
+	// the addresses of the memory are only known now
+	for _, f := range a.addresses {
+		binary.LittleEndian.PutUint32(code[f.at:], uint32(memory+f.offset(tape)))
+	}
+
+	var file bytes.Buffer
+	header := elf.Header64{
+		Ident: [elf.EI_NIDENT]byte{
+			0x7f, 'E', 'L', 'F',
+			byte(elf.ELFCLASS64),
+			byte(elf.ELFDATA2LSB),
+			byte(elf.EV_CURRENT),
+			byte(elf.ELFOSABI_NONE),
+		},
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_X86_64),
+		Version:   uint32(elf.EV_CURRENT),
+		Entry:     base + headerSize,
+		Phoff:     64,
+		Ehsize:    64,
+		Phentsize: 56,
+		Phnum:     2,
+	}
+
+	segments := []elf.Prog64{
+		{
+			// headers and code
+			Type:   uint32(elf.PT_LOAD),
+			Flags:  uint32(elf.PF_R | elf.PF_X),
+			Off:    0,
+			Vaddr:  base,
+			Paddr:  base,
+			Filesz: headerSize + uint64(len(code)),
+			Memsz:  headerSize + uint64(len(code)),
+			Align:  pageSize,
+		},
+		{
+			// memory tape and buffers, which are zeroed
+			Type:  uint32(elf.PT_LOAD),
+			Flags: uint32(elf.PF_R | elf.PF_W),
+			Vaddr: memory,
+			Paddr: memory,
+			Memsz: inbuf + bufferSize - memory,
+			Align: pageSize,
+		},
+	}
+
+	// writes to a bytes.Buffer don't fail
+	_ = binary.Write(&file, binary.LittleEndian, header)
+	_ = binary.Write(&file, binary.LittleEndian, segments)
+	file.Write(code)
+
+	return file.Bytes()
+}
+
+// compile compiles the given chunk into machine code, followed by the
+// subroutines it uses.
+func compile(a *assembler, c *instruction.Chunk, eof targets.EOF) []byte {
+	r := runtime{
+		putc:  a.newLabel(),
+		flush: a.newLabel(),
+		getc:  a.newLabel(),
+		fail:  a.newLabel(),
+	}
+
+	// %rbx: memory pointer
+	// %r12: length of the output buffer
+	// %r13, %r14: position in and length of the input buffer
+	a.emit(0xbb) // movl $tape, %ebx
+	a.address(tapeAddress)
+	a.emit(0x45, 0x31, 0xe4) // xorl %r12d, %r12d
+	a.emit(0x45, 0x31, 0xed) // xorl %r13d, %r13d
+	a.emit(0x45, 0x31, 0xf6) // xorl %r14d, %r14d
+
+	// start and end labels of loops and ifs
+	type block struct{ start, end label }
+	var blocks []block
+
+	length := c.Len()
+	for i := 0; i < length; i++ {
+		ins := c.Instruction(i)
+
+		switch v := ins.(type) {
+		case instruction.Value:
+			a.addCell(v.Offset, v.X)
+
+		case instruction.Set:
+			a.setCell(v.Offset, v.X)
+
+		case instruction.Mul:
+			// the destination must not be accessed if the source is zero
+			a.emit(0x0f, 0xb6) // movzbl source(%rbx), %eax
+			a.mem(0, v.Source)
+			a.emit(0x85, 0xc0) // testl %eax, %eax
+
+			skip := 1 + memLen(v.Offset)
+			switch int8(v.X) {
+			case 1:
+				a.emit(0x74, byte(skip)) // jz 1f
+				a.emit(0x00)             // addb %al, offset(%rbx)
+			case -1:
+				a.emit(0x74, byte(skip)) // jz 1f
+				a.emit(0x28)             // subb %al, offset(%rbx)
+			default:
+				a.emit(0x74, byte(3+skip)) // jz 1f
+				a.emit(0x6b, 0xc0, v.X)    // imull $x, %eax, %eax
+				a.emit(0x00)               // addb %al, offset(%rbx)
+			}
+			a.mem(0, v.Offset)
+
+		case instruction.Input:
+			// getc returns -1 on EOF
+			a.jump(call, r.getc)
+			switch eof {
+			case targets.EOFUnchanged:
+				a.emit(0x85, 0xc0)                     // testl %eax, %eax
+				a.emit(0x78, byte(1+memLen(v.Offset))) // js 1f
+			case targets.EOFZero:
+				a.emit(0x85, 0xc0) // testl %eax, %eax
+				a.emit(0x79, 0x02) // jns 1f
+				a.emit(0x31, 0xc0) // xorl %eax, %eax
+			}
+			a.storeCell(v.Offset)
+
+		case instruction.Output:
+			a.loadCell(v.Offset)
+			a.jump(call, r.putc)
+
+		case instruction.Print:
+			a.emit(0xb0, v.X) // movb $x, %al
+			a.jump(call, r.putc)
+
+		case instruction.StartLoop:
+			b := block{start: a.newLabel(), end: a.newLabel()}
+			blocks = append(blocks, b)
+
+			if v.Balanced {
+				a.testCell(v.Offset)
+			} else {
+				// the pointer is moved before the loop is entered, and at
+				// the end of every iteration
+				a.move(v.Offset)
+				a.testCell(0)
+			}
+
+			a.jump(je, b.end)
+			a.bind(b.start)
+
+		case instruction.EndLoop:
+			b := blocks[len(blocks)-1]
+			blocks = blocks[:len(blocks)-1]
+
+			if v.Balanced {
+				a.testCell(v.Offset)
+			} else {
+				a.move(v.Offset)
+				a.testCell(0)
+			}
+
+			a.jump(jne, b.start)
+			a.bind(b.end)
+
+		case instruction.If:
+			b := block{end: a.newLabel()}
+			blocks = append(blocks, b)
+
+			a.testCell(v.Offset)
+			a.jump(je, b.end)
+
+		case instruction.EndIf:
+			a.bind(blocks[len(blocks)-1].end)
+			blocks = blocks[:len(blocks)-1]
+
+		default:
+			// unreachable
+			t := reflect.ValueOf(ins).Type() // get instruction type
+			panic(fmt.Sprintf("elf: compile: invalid instruction type %s in chunk", t))
+		}
+	}
+
+	// exit(0)
+	a.jump(call, r.flush)
+	a.emit(0xb8, 60, 0, 0, 0) // movl $60, %eax
+	a.emit(0x31, 0xff)        // xorl %edi, %edi
+	a.emit(0x0f, 0x05)        // syscall
+
+	r.compile(a)
+	a.link()
+	return a.code
+}
+
+// runtime contains the labels of the subroutines used by compiled
+// programs, which are the same as those of the amd64 target.
+type runtime struct {
+	putc  label // appends %al to the output buffer, flushing it if full
+	flush label // writes the output buffer to stdout
+	getc  label // returns the next byte of input in %eax, or -1 on EOF
+	fail  label // exits with status 1 if a system call fails
+}
+
+// compile compiles the subroutines.
+func (r runtime) compile(a *assembler) {
+	a.bind(r.putc)
+	a.emit(0xbe) // movl $outbuf, %esi
+	a.address(outbufAddress)
+	a.emit(0x42, 0x88, 0x04, 0x26) // movb %al, (%rsi,%r12)
+	a.emit(0x49, 0xff, 0xc4)       // incq %r12
+	a.emit(0x49, 0x81, 0xfc)       // cmpq $bufferSize, %r12
+	a.imm32(bufferSize)
+	a.jump(je, r.flush) // tail call
+	a.emit(0xc3)        // ret
+
+	a.bind(r.flush)
+	done, write := a.newLabel(), a.newLabel()
+	a.emit(0xbe) // movl $outbuf, %esi
+	a.address(outbufAddress)
+	a.emit(0x4d, 0x85, 0xe4) // testq %r12, %r12
+	a.jump(je, done)
+	a.bind(write)
+	a.emit(0xb8, 1, 0, 0, 0) // movl $1, %eax
+	a.emit(0xbf, 1, 0, 0, 0) // movl $1, %edi
+	a.emit(0x4c, 0x89, 0xe2) // movq %r12, %rdx
+	a.emit(0x0f, 0x05)       // syscall
+	a.emit(0x48, 0x85, 0xc0) // testq %rax, %rax
+	a.jump(jle, r.fail)
+	a.emit(0x48, 0x01, 0xc6) // addq %rax, %rsi
+	a.emit(0x49, 0x29, 0xc4) // subq %rax, %r12
+	a.jump(jne, write)
+	a.bind(done)
+	a.emit(0xc3) // ret
+
+	a.bind(r.getc)
+	buffered, eof := a.newLabel(), a.newLabel()
+	a.emit(0x4d, 0x39, 0xf5) // cmpq %r14, %r13
+	a.jump(jb, buffered)
+	a.jump(call, r.flush) // flush pending output before blocking
+	a.emit(0x31, 0xc0)    // xorl %eax, %eax
+	a.emit(0x31, 0xff)    // xorl %edi, %edi
+	a.emit(0xbe)          // movl $inbuf, %esi
+	a.address(inbufAddress)
+	a.emit(0xba) // movl $bufferSize, %edx
+	a.imm32(bufferSize)
+	a.emit(0x0f, 0x05)       // syscall
+	a.emit(0x48, 0x85, 0xc0) // testq %rax, %rax
+	a.jump(js, r.fail)
+	a.jump(je, eof)
+	a.emit(0x49, 0x89, 0xc6) // movq %rax, %r14
+	a.emit(0x45, 0x31, 0xed) // xorl %r13d, %r13d
+	a.bind(buffered)
+	a.emit(0xbe) // movl $inbuf, %esi
+	a.address(inbufAddress)
+	a.emit(0x42, 0x0f, 0xb6, 0x04, 0x2e) // movzbl (%rsi,%r13), %eax
+	a.emit(0x49, 0xff, 0xc5)             // incq %r13
+	a.emit(0xc3)                         // ret
+	a.bind(eof)
+	a.emit(0xb8, 0xff, 0xff, 0xff, 0xff) // movl $-1, %eax
+	a.emit(0xc3)                         // ret
+
+	a.bind(r.fail)
+	a.emit(0xb8, 60, 0, 0, 0) // movl $60, %eax
+	a.emit(0xbf, 1, 0, 0, 0)  // movl $1, %edi
+	a.emit(0x0f, 0x05)        // syscall
+}
+
+// alignUp rounds x up to a multiple of align, which is a power of 2.
+func alignUp(x, align uint64) uint64 {
+	return (x + align - 1) &^ (align - 1)
+}
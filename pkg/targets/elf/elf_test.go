@@ -0,0 +1,67 @@
+package elf_test
+
+import (
+	"bytes"
+	"context"
+	"debug/elf"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"laptudirm.com/x/brainfuck/pkg/lexer"
+	"laptudirm.com/x/brainfuck/pkg/parser"
+	elftarget "laptudirm.com/x/brainfuck/pkg/targets/elf"
+	"laptudirm.com/x/brainfuck/pkg/targets/internal/targettest"
+)
+
+var compileTests = append([]targettest.Test{
+	// offsets which don't fit in a byte
+	{Src: "+[" + strings.Repeat(">", 300) + "],." + strings.Repeat("<", 300) + ".", Input: "A", Output: "A\x01"},
+}, targettest.Tests...)
+
+func TestCompile(t *testing.T) {
+	dir := t.TempDir()
+	for n, test := range compileTests {
+		chunk, err := parser.Parse(lexer.Lex([]byte(test.Src)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exe := elftarget.Compile(chunk, elftarget.Options{EOF: test.EOF})
+		f, err := elf.NewFile(bytes.NewReader(exe))
+		if err != nil {
+			t.Fatalf("%d: %v", n, err)
+		}
+
+		if f.Class != elf.ELFCLASS64 || f.Machine != elf.EM_X86_64 || f.Type != elf.ET_EXEC || len(f.Progs) != 2 {
+			t.Fatalf("%d: unexpected file header %+v", n, f.FileHeader)
+		}
+
+		if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+			// the executable can't be run
+			continue
+		}
+
+		bin := filepath.Join(dir, "prog")
+		if err := os.WriteFile(bin, exe, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		cmd := exec.CommandContext(ctx, bin)
+		cmd.Stdin = strings.NewReader(test.Input)
+		output, err := cmd.Output()
+		cancel()
+		if err != nil {
+			t.Fatalf("%d: %v", n, err)
+		}
+
+		if !bytes.Equal(output, []byte(test.Output)) {
+			t.Errorf("%d: expected output %q, received %q", n, test.Output, output)
+		}
+	}
+}
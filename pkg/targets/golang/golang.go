@@ -0,0 +1,459 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package golang implements the Go compilation target, which compiles an
+// instruction.Chunk into a Go package with no dependencies other than the
+// standard library. The package exports the following API:
+//
+//	// ErrOutOfBounds is returned by Run if the pointer is moved out of
+//	// the memory tape.
+//	var ErrOutOfBounds = errors.New("pointer out of bounds")
+//
+//	// Run runs the program, which reads it's input from r and writes it's
+//	// output to w.
+//	func Run(r io.Reader, w io.Writer) error
+//
+// Cells are bounds checked before they are accessed, and the pointer after
+// it is moved, so Run returns ErrOutOfBounds before any side effects of the
+// out of bounds access. The checks are left out if all the cells accessed
+// by the program are in bounds, and loops and ifs whose range is bounded
+// are compiled twice, without checks if all the cells in the range are in
+// bounds, and with them otherwise. Panics in r and w are never recovered.
+//
+// The package can be generated from a brainfuck program with go generate,
+// using a directive like:
+//
+//	//go:generate brainfuck build --target=go -o hello.go hello.bf
+package golang
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+	"laptudirm.com/x/brainfuck/pkg/targets"
+)
+
+// Options configures the compiled package.
+type Options struct {
+	Package  string      // name of the package
+	TapeSize int         // number of cells, or 0 for targets.DefaultTapeSize
+	EOF      targets.EOF // what input stores in a cell on EOF
+}
+
+// Compile compiles an instruction.Chunk into the source code of a Go
+// package, which is formatted like gofmt. It returns an error if the name
+// of the package or the tape size is invalid.
+func Compile(c *instruction.Chunk, o Options) ([]byte, error) {
+	instruction.VerifyDebug(c)
+
+	if !token.IsIdentifier(o.Package) || o.Package == "_" {
+		return nil, fmt.Errorf("golang: invalid package name %q", o.Package)
+	}
+
+	tape := o.TapeSize
+	switch {
+	case tape == 0:
+		tape = targets.DefaultTapeSize
+	case tape < 0:
+		return nil, fmt.Errorf("golang: invalid tape size %d", tape)
+	}
+
+	body := writer{
+		depth:   1,
+		eof:     o.EOF,
+		ranges:  instruction.Ranges(c),
+		checked: true,
+		split:   true,
+	}
+
+	// the pointer starts at the first cell, so nothing needs to be checked
+	// if all the cells accessed are in the tape
+	if r := instruction.Footprint(c); r.Bounded() && r.Min >= 0 && r.Max < tape {
+		body.checked = false
+	}
+
+	body.block(c, 0, c.Len())
+
+	var w writer
+	w.line("// Code generated by brainfuck. DO NOT EDIT.")
+	w.line("")
+	w.line("package %s", o.Package)
+	w.line("")
+	w.line("import (")
+	w.line("\t\"bufio\"")
+	w.line("\t\"errors\"")
+	w.line("\t\"io\"")
+	w.line(")")
+	w.line("")
+	w.line("// ErrOutOfBounds is returned by Run if the pointer is moved out of the")
+	w.line("// memory tape.")
+	w.line("var ErrOutOfBounds = errors.New(\"pointer out of bounds\")")
+	w.line("")
+	w.line("// Run runs the program, which reads it's input from r and writes it's")
+	w.line("// output to w.")
+	w.line("func Run(r io.Reader, w io.Writer) (err error) {")
+	if body.input {
+		w.line("\tin := bufio.NewReader(r)")
+	}
+	w.line("\tout := bufio.NewWriter(w)")
+	w.line("")
+	w.line("\tdefer func() {")
+	w.line("\t\t// write any output from before an error")
+	w.line("\t\tif flushErr := out.Flush(); err == nil {")
+	w.line("\t\t\terr = flushErr")
+	w.line("\t\t}")
+	w.line("\t}()")
+	w.line("")
+	if body.memory {
+		w.line("\tm := make([]byte, %d)", tape)
+		w.line("\tp := 0")
+	}
+	w.line("")
+	w.dst.Write(body.dst.Bytes())
+	w.line("")
+	w.line("\treturn nil")
+	w.line("}")
+
+	src, err := format.Source(w.dst.Bytes())
+	if err != nil {
+		// the generated code is always valid, unreachable
+		panic(fmt.Sprintf("golang: compile: invalid generated code: %v", err))
+	}
+
+	return src, nil
+}
+
+// block writes the code of the instructions from start to end.
+func (w *writer) block(c *instruction.Chunk, start, end int) {
+	fresh := true // whether a new run of instructions starts
+	for i := start; i < end; i++ {
+		ins := c.Instruction(i)
+
+		switch ins.(type) {
+		case instruction.StartLoop, instruction.If:
+			if w.checked && w.split && w.ranges[i].Bounded() {
+				j := matchingEnd(c, i) + 1
+				w.hoist(c, i, j)
+				i = j - 1
+				fresh = true
+				continue
+			}
+		}
+
+		if fresh && w.checked {
+			w.lo, w.hi = run(c, i)
+			w.check(w.lo, w.hi)
+		}
+		fresh = false
+
+		switch v := ins.(type) {
+		case instruction.Value:
+			if x := int8(v.X); x < 0 {
+				w.line("m[%s] -= %d", w.cell(v.Offset), -int(x))
+			} else {
+				w.line("m[%s] += %d", w.cell(v.Offset), x)
+			}
+
+		case instruction.Set:
+			w.line("m[%s] = %d", w.cell(v.Offset), v.X)
+
+		case instruction.Mul:
+			// the destination must not be accessed, or bounds checked, if
+			// the source is zero
+			source := w.cell(v.Source)
+			w.line("if m[%s] != 0 {", source)
+			if w.checked && (v.Offset < w.lo || v.Offset > w.hi) {
+				w.depth++
+				w.check(v.Offset, v.Offset)
+				w.depth--
+			}
+			if x := int8(v.X); x < 0 {
+				w.line("\tm[%s] -= m[%s] * %d", w.cell(v.Offset), source, -int(x))
+			} else {
+				w.line("\tm[%s] += m[%s] * %d", w.cell(v.Offset), source, x)
+			}
+			w.line("}")
+
+		case instruction.Input:
+			w.input = true
+			cell := w.cell(v.Offset)
+
+			// flush any pending output, like prompts, before blocking
+			w.line("if err := out.Flush(); err != nil {")
+			w.line("\treturn err")
+			w.line("}")
+
+			w.line("if b, err := in.ReadByte(); err == nil {")
+			w.line("\tm[%s] = b", cell)
+			switch w.eof {
+			case targets.EOFUnchanged:
+				w.line("} else if err != io.EOF {")
+				w.line("\treturn err")
+			case targets.EOFZero:
+				w.line("} else if err == io.EOF {")
+				w.line("\tm[%s] = 0", cell)
+				w.line("} else {")
+				w.line("\treturn err")
+			case targets.EOFMinusOne:
+				w.line("} else if err == io.EOF {")
+				w.line("\tm[%s] = 255", cell)
+				w.line("} else {")
+				w.line("\treturn err")
+			}
+			w.line("}")
+			fresh = true
+
+		case instruction.Output:
+			w.write(fmt.Sprintf("m[%s]", w.cell(v.Offset)))
+			fresh = true
+
+		case instruction.Print:
+			w.write(strconv.QuoteRuneToASCII(rune(v.X)))
+			fresh = true
+
+		case instruction.StartLoop:
+			if v.Balanced {
+				w.line("for m[%s] != 0 {", w.cell(v.Offset))
+			} else {
+				// the pointer is moved before the loop is entered, and at
+				// the end of every iteration
+				w.move(v.Offset)
+				w.line("for m[p] != 0 {")
+			}
+
+			w.depth++
+			fresh = true
+
+		case instruction.EndLoop:
+			if !v.Balanced {
+				w.move(v.Offset)
+			}
+
+			w.depth--
+			w.line("}")
+			fresh = true
+
+		case instruction.If:
+			w.line("if m[%s] != 0 {", w.cell(v.Offset))
+			w.depth++
+			fresh = true
+
+		case instruction.EndIf:
+			w.depth--
+			w.line("}")
+			fresh = true
+
+		default:
+			// unreachable
+			t := reflect.ValueOf(ins).Type() // get instruction type
+			panic(fmt.Sprintf("golang: compile: invalid instruction type %s in chunk", t))
+		}
+	}
+}
+
+// hoist writes the code of the loop or if from start to end without bounds
+// checks, guarded by a check of all the cells in it's range. The code with
+// checks is written in the else branch, since the range only contains the
+// cells which may be accessed.
+func (w *writer) hoist(c *instruction.Chunk, start, end int) {
+	r := w.ranges[start]
+
+	// the pointer itself is always in bounds
+	var bounds []string
+	if r.Min < 0 {
+		bounds = append(bounds, w.cell(r.Min)+" >= 0")
+	}
+	if r.Max > 0 {
+		bounds = append(bounds, w.cell(r.Max)+" < len(m)")
+	}
+
+	w.checked = false
+	if len(bounds) == 0 {
+		w.block(c, start, end)
+		w.checked = true
+		return
+	}
+
+	w.line("if %s {", strings.Join(bounds, " && "))
+	w.depth++
+	w.block(c, start, end)
+	w.depth--
+	w.line("} else {")
+	w.depth++
+	w.checked, w.split = true, false
+	w.block(c, start, end)
+	w.split = true
+	w.depth--
+	w.line("}")
+}
+
+// writer writes indented lines of Go code.
+type writer struct {
+	dst   bytes.Buffer
+	depth int // indentation depth
+
+	memory bool // whether the memory tape is used
+	input  bool // whether input is read
+
+	eof    targets.EOF         // what input stores in a cell on EOF
+	ranges []instruction.Range // ranges of the chunk's instructions
+
+	checked bool // whether cells are bounds checked
+	split   bool // whether bounded loops and ifs are hoisted
+	lo, hi  int  // offsets of the cells checked by the current run
+}
+
+// line writes a line of code at the current depth.
+func (w *writer) line(format string, a ...interface{}) {
+	if format != "" {
+		w.dst.WriteString(strings.Repeat("\t", w.depth))
+		fmt.Fprintf(&w.dst, format, a...)
+	}
+
+	w.dst.WriteByte('\n')
+}
+
+// cell returns an expression which indexes the cell at the given offset.
+func (w *writer) cell(offset int) string {
+	w.memory = true
+	switch {
+	case offset > 0:
+		return fmt.Sprintf("p+%d", offset)
+	case offset < 0:
+		return fmt.Sprintf("p-%d", -offset)
+	default:
+		return "p"
+	}
+}
+
+// move writes code which moves the pointer by the given offset, and checks
+// that it is still in bounds.
+func (w *writer) move(offset int) {
+	w.memory = true
+	switch {
+	case offset > 0:
+		w.line("p += %d", offset)
+		w.line("if p >= len(m) {")
+	case offset < 0:
+		w.line("p -= %d", -offset)
+		w.line("if p < 0 {")
+	default:
+		return
+	}
+
+	w.line("\treturn ErrOutOfBounds")
+	w.line("}")
+}
+
+// check writes code which checks that the cells from offset lo to hi are
+// in bounds. The pointer itself is always in bounds.
+func (w *writer) check(lo, hi int) {
+	switch {
+	case lo < 0 && hi > 0:
+		w.line("if %s < 0 || %s >= len(m) {", w.cell(lo), w.cell(hi))
+	case lo < 0:
+		w.line("if %s < 0 {", w.cell(lo))
+	case hi > 0:
+		w.line("if %s >= len(m) {", w.cell(hi))
+	default:
+		return
+	}
+
+	w.line("\treturn ErrOutOfBounds")
+	w.line("}")
+}
+
+// write writes code which writes the given byte to the output, and returns
+// any error, so that programs stop when the output is closed.
+func (w *writer) write(b string) {
+	w.line("if err := out.WriteByte(%s); err != nil {", b)
+	w.line("\treturn err")
+	w.line("}")
+}
+
+// run returns the offsets of the first and last cells accessed by every
+// execution of the run of instructions starting at the given index. A run
+// ends after the first instruction which branches or performs output, so
+// that checking the cells at it's start doesn't skip any side effects. The
+// destination of a Mul isn't always accessed, so it's checked separately.
+func run(c *instruction.Chunk, start int) (lo, hi int) {
+	access := func(offset int) {
+		if offset < lo {
+			lo = offset
+		}
+
+		if offset > hi {
+			hi = offset
+		}
+	}
+
+	length := c.Len()
+	for i := start; i < length; i++ {
+		switch v := c.Instruction(i).(type) {
+		case instruction.Value:
+			access(v.Offset)
+		case instruction.Set:
+			access(v.Offset)
+		case instruction.Mul:
+			access(v.Source)
+		case instruction.Input:
+			access(v.Offset)
+			return
+		case instruction.Output:
+			access(v.Offset)
+			return
+		case instruction.StartLoop:
+			// the cell of an unbalanced loop is checked after the pointer
+			// is moved
+			if v.Balanced {
+				access(v.Offset)
+			}
+			return
+		case instruction.EndLoop:
+			if v.Balanced {
+				access(v.Offset)
+			}
+			return
+		case instruction.If:
+			access(v.Offset)
+			return
+		default:
+			// Print and EndIf
+			return
+		}
+	}
+
+	return
+}
+
+// matchingEnd returns the index of the EndLoop or EndIf which closes the
+// loop or if starting at the given index.
+func matchingEnd(c *instruction.Chunk, start int) int {
+	depth := 0
+	for i := start; ; i++ {
+		switch c.Instruction(i).(type) {
+		case instruction.StartLoop, instruction.If:
+			depth++
+		case instruction.EndLoop, instruction.EndIf:
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package golang_test
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"text/template"
+
+	"laptudirm.com/x/brainfuck/pkg/lexer"
+	"laptudirm.com/x/brainfuck/pkg/parser"
+	"laptudirm.com/x/brainfuck/pkg/targets/golang"
+	"laptudirm.com/x/brainfuck/pkg/targets/internal/targettest"
+)
+
+// compileTest is a test program, which may fail with an error.
+type compileTest struct {
+	targettest.Test
+	writer string // writer used by Run, see mainTemplate
+	err    string // error returned by Run, if any
+}
+
+var compileTests = []compileTest{
+	{Test: targettest.Test{Src: "++++[->++++<]>[.-]", Output: "\x10\x0f\x0e\x0d\x0c\x0b\x0a\x09\x08\x07\x06\x05\x04\x03\x02\x01"}},
+	{Test: targettest.Test{Src: "+.[<+.]", Output: "\x01"}, err: "pointer out of bounds"},
+	{Test: targettest.Test{Src: "+[>+]", Output: ""}, err: "pointer out of bounds"},
+
+	// the destination of a Mul is only accessed if the source isn't zero
+	{Test: targettest.Test{Src: ",[-<+>]+.", Output: "\x01"}},
+	{Test: targettest.Test{Src: ",[-<+>]+.", Input: "a", Output: ""}, err: "pointer out of bounds"},
+
+	// loops whose range is bounded are only checked if it's out of bounds
+	{Test: targettest.Test{Src: ",[>,.<-]+[<+.]", Input: "\x02ab", Output: "ab"}, err: "pointer out of bounds"},
+	{Test: targettest.Test{Src: ",[<,.>-]", Input: "\x01a", Output: ""}, err: "pointer out of bounds"},
+	{Test: targettest.Test{Src: ",[>,[-<<,.>>]<-]", Input: "\x01\x00", Output: ""}},
+
+	// errors and panics of the writer
+	{Test: targettest.Test{Src: "+[.]"}, writer: "closed", err: "closed pipe"},
+	{Test: targettest.Test{Src: "+."}, writer: "broken", err: "panic: runtime error: index out of range [1] with length 0"},
+}
+
+// mainTemplate is the main package which runs every compiled program, and
+// prints it's output and error.
+var mainTemplate = template.Must(template.New("main").Parse(`package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+{{range .}}
+	"test/{{.Name}}"
+{{- end}}
+)
+
+func main() {
+{{- range .}}
+	run({{.Name}}.Run, {{printf "%q" .Input}}, {{printf "%q" .Writer}})
+{{- end}}
+}
+
+func run(f func(r io.Reader, w io.Writer) error, input, writer string) {
+	var b strings.Builder
+	defer func() {
+		if e := recover(); e != nil {
+			fmt.Printf("%q panic: %v\n", b.String(), e)
+		}
+	}()
+
+	var w io.Writer = &b
+	switch writer {
+	case "closed":
+		w = closed{}
+	case "broken":
+		w = broken{}
+	}
+
+	err := f(strings.NewReader(input), w)
+	fmt.Printf("%q %v\n", b.String(), err)
+}
+
+// closed is a writer which always fails.
+type closed struct{}
+
+func (closed) Write(p []byte) (int, error) {
+	return 0, errors.New("closed pipe")
+}
+
+// broken is a writer which panics with a runtime error.
+type broken struct {
+	buf []byte
+}
+
+func (b broken) Write(p []byte) (int, error) {
+	return int(b.buf[len(p)]), nil
+}
+`))
+
+// program is a compiled package which is run by the main package.
+type program struct {
+	Name   string
+	Input  string
+	Writer string
+}
+
+func TestCompile(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go command found")
+	}
+
+	tests := append([]compileTest(nil), compileTests...)
+	for _, test := range targettest.Tests {
+		tests = append(tests, compileTest{Test: test})
+	}
+
+	// build every program as a package of a module, and run them from a
+	// single main package
+	dir := t.TempDir()
+	programs := make([]program, len(tests))
+	for n, test := range tests {
+		chunk, err := parser.Parse(lexer.Lex([]byte(test.Src)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name := fmt.Sprintf("p%d", n)
+		code, err := golang.Compile(chunk, golang.Options{Package: name, EOF: test.EOF})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.MkdirAll(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, name, name+".go"), code, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		programs[n] = program{Name: name, Input: test.Input, Writer: test.writer}
+	}
+
+	var main strings.Builder
+	if err := mainTemplate.Execute(&main, programs); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"go.mod":  "module test\n\ngo 1.18\n",
+		"main.go": main.String(),
+	}
+
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(gobin, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=", "GO111MODULE=on")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != len(tests) {
+		t.Fatalf("expected %d results, received:\n%s", len(tests), out)
+	}
+
+	for n, test := range tests {
+		err := test.err
+		if err == "" {
+			err = "<nil>"
+		}
+
+		if expected := strconv.Quote(test.Output) + " " + err; lines[n] != expected {
+			t.Errorf("%q: expected %s, received %s", test.Src, expected, lines[n])
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	chunk, err := parser.Parse(lexer.Lex([]byte("+.")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, o := range []golang.Options{
+		{Package: ""},
+		{Package: "_"},
+		{Package: "1p"},
+		{Package: "func"},
+		{Package: "hello-world"},
+		{Package: "p", TapeSize: -1},
+	} {
+		if _, err := golang.Compile(chunk, o); err == nil {
+			t.Errorf("%+v: expected an error", o)
+		}
+	}
+}
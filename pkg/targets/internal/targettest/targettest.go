@@ -0,0 +1,100 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package targettest provides the test programs which are shared by the
+// tests of the compilation targets that produce runnable programs.
+package targettest
+
+import (
+	"bytes"
+	"strings"
+
+	"laptudirm.com/x/brainfuck/pkg/targets"
+)
+
+// Test is a brainfuck program, along with it's input and expected output.
+type Test struct {
+	Src    string
+	EOF    targets.EOF
+	Input  string
+	Output string
+}
+
+// Tests should produce the same output on every target.
+var Tests = []Test{
+	{
+		Src:    "++++++++[>++++[>++>+++>+++>+<<<<-]>+>+>->>+[<]<-]>>.>---.+++++++..+++.>>.<-.<.+++.------.--------.>>+.>++.",
+		Output: "Hello World!\n",
+	},
+	{Src: ",[.,]", EOF: targets.EOFZero, Input: "echo", Output: "echo"},
+	{Src: ",[->++<]>.", Input: "!", Output: "B"},
+	{Src: ",[>+.<[-]]+[->+.<]>.", Input: "x", Output: "\x01\x02\x02"},
+	{Src: "+>+>+<<[>]<.", Output: "\x01"},
+
+	// more input and output than the size of common buffers
+	{Src: strings.Repeat("+", 80) + "[>++++++++[>++++++++[>+.<-]<-]<-]", Output: Count(5120)},
+	{Src: ",[.,]", EOF: targets.EOFZero, Input: strings.Repeat("ab", 3000), Output: strings.Repeat("ab", 3000)},
+
+	// EOF rules
+	{Src: "+,.", EOF: targets.EOFUnchanged, Output: "\x01"},
+	{Src: "+,.", EOF: targets.EOFZero, Output: "\x00"},
+	{Src: "+,.", EOF: targets.EOFMinusOne, Output: "\xff"},
+}
+
+// Count returns n bytes counting up from 1, wrapping at 256.
+func Count(n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(i + 1)
+	}
+
+	return string(b)
+}
+
+// commands is used to convert fuzzer data into brainfuck commands.
+const commands = "+-<>,.[]"
+
+// Program converts fuzzer data into a brainfuck program with matched
+// brackets.
+func Program(data []byte) []byte {
+	var src []byte
+	depth := 0
+	for _, b := range data {
+		c := commands[int(b)%len(commands)]
+		if c == ']' && depth == 0 {
+			continue
+		}
+
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		}
+
+		src = append(src, c)
+	}
+
+	return append(src, bytes.Repeat([]byte{']'}, depth)...)
+}
+
+// Data converts a brainfuck program into fuzzer data which is converted
+// back to the same program by Program.
+func Data(src string) []byte {
+	d := make([]byte, len(src))
+	for i := range src {
+		d[i] = byte(strings.IndexByte(commands, src[i]))
+	}
+
+	return d
+}
@@ -22,14 +22,42 @@ import (
 
 // Compile compiles an instruction.Chunk into opcode, which is represented by
 // a slice of integers.
+//
+// The bounds checks of the cells accessed by the chunk are done once at
+// it's start if the range of the cells is bounded, and otherwise once at
+// the start of every outermost loop or if whose range is bounded. The VM
+// still checks every access if the range isn't within the memory, since
+// not all the cells in it may be accessed.
 func Compile(c *instruction.Chunk) []int {
+	instruction.VerifyDebug(c)
+
 	var dst []int   // result slice
 	var stack []int // loop stack
 
 	length := c.Len()
+	ranges := instruction.Ranges(c)
+
+	// whole reports whether the checks of the whole chunk are hoisted, and
+	// hoisted is the depth of the loop or if whose checks are, or -1
+	whole, hoisted := false, -1
+	if r := instruction.Footprint(c); length > 0 && r.Bounded() {
+		// [code] [min] [max]
+		dst = append(dst, int(CheckRange), r.Min, r.Max)
+		whole = true
+	}
+
 	for i := 0; i < length; i++ {
 		ins := c.Instruction(i)
 
+		switch ins.(type) {
+		case instruction.StartLoop, instruction.If:
+			if r := ranges[i]; !whole && hoisted < 0 && r.Bounded() {
+				// [code] [min] [max]
+				dst = append(dst, int(CheckRange), r.Min, r.Max)
+				hoisted = len(stack)
+			}
+		}
+
 		switch v := ins.(type) {
 		case instruction.Value:
 			// [code] [offset] [amount]
@@ -43,9 +71,18 @@ func Compile(c *instruction.Chunk) []int {
 			// [code] [offset]
 			dst = append(dst, int(OutputByte), v.Offset)
 
+		case instruction.Print:
+			// [code] [value]
+			dst = append(dst, int(PrintByte), int(v.X))
+
 		case instruction.StartLoop:
+			code := JumpIfZero
+			if v.Balanced {
+				code = JumpIfZeroAt
+			}
+
 			// [code] [offset] [jump-offset]
-			dst = append(dst, int(JumpIfZero), v.Offset, 0)
+			dst = append(dst, int(code), v.Offset, 0)
 			stack = append(stack, len(dst))
 
 		case instruction.EndLoop:
@@ -54,8 +91,13 @@ func Compile(c *instruction.Chunk) []int {
 				panic("opcode: compile: unexpected EndLoop instruction in chunk")
 			}
 
+			code := JumpIfNotZero
+			if v.Balanced {
+				code = JumpIfNotZeroAt
+			}
+
 			// [code] [offset] [jump-offset]
-			dst = append(dst, int(JumpIfNotZero), v.Offset, 0)
+			dst = append(dst, int(code), v.Offset, 0)
 
 			start := stack[len(stack)-1] // get loop start index
 			stack = stack[:len(stack)-1] // pop loop index
@@ -66,10 +108,43 @@ func Compile(c *instruction.Chunk) []int {
 			// backpatch jump-offsets
 			dst[len(dst)-1], dst[start-1] = diff, diff
 
+			if len(stack) == hoisted {
+				// [code]
+				dst = append(dst, int(EndRange))
+				hoisted = -1
+			}
+
+		case instruction.If:
+			// [code] [offset] [jump-offset]
+			dst = append(dst, int(SkipIfZero), v.Offset, 0)
+			stack = append(stack, len(dst))
+
+		case instruction.EndIf:
+			if len(stack) == 0 {
+				// no ifs opened, unreachable
+				panic("opcode: compile: unexpected EndIf instruction in chunk")
+			}
+
+			start := stack[len(stack)-1] // get if start index
+			stack = stack[:len(stack)-1] // pop if index
+
+			// backpatch jump-offset to skip to the end of the block
+			dst[start-1] = len(dst) - start
+
+			if len(stack) == hoisted {
+				// [code]
+				dst = append(dst, int(EndRange))
+				hoisted = -1
+			}
+
 		case instruction.Set:
 			// [code] [offset] [amount]
 			dst = append(dst, int(SetValue), v.Offset, int(v.X))
 
+		case instruction.Mul:
+			// [code] [offset] [source] [amount]
+			dst = append(dst, int(MulValue), v.Offset, v.Source, int(v.X))
+
 		default:
 			// unreachable
 			t := reflect.ValueOf(ins).Elem().Type() // get instruction type
@@ -79,7 +154,7 @@ func Compile(c *instruction.Chunk) []int {
 
 	if len(stack) > 0 {
 		// unclosed loops, unreachable
-		panic("opcode: compile: unexpected end of chunk, unpaired StartLoop instructions")
+		panic("opcode: compile: unexpected end of chunk, unpaired StartLoop or If instructions")
 	}
 
 	return dst
@@ -29,4 +29,21 @@ const (
 	SetValue      // [code] [offset] [amount]
 	JumpIfZero    // [code] [offset] [jump-offset]
 	JumpIfNotZero // [code] [offset] [jump-offset]
+	PrintByte     // [code] [value]
+
+	// variants of the jump instructions for balanced loops, which test the
+	// cell at the offset without moving the pointer
+	JumpIfZeroAt    // [code] [offset] [jump-offset]
+	JumpIfNotZeroAt // [code] [offset] [jump-offset]
+
+	// conditional skip for if blocks, which tests the cell at the offset
+	// without moving the pointer
+	SkipIfZero // [code] [offset] [jump-offset]
+
+	MulValue // [code] [offset] [source] [amount]
+
+	// bounds checks of the cells accessed until the next EndRange are
+	// skipped if all the cells from min to max are in bounds
+	CheckRange // [code] [min] [max]
+	EndRange   // [code]
 )
@@ -0,0 +1,169 @@
+package opcode_test
+
+import (
+	"bytes"
+	"testing"
+
+	"laptudirm.com/x/brainfuck/pkg/instruction"
+	"laptudirm.com/x/brainfuck/pkg/lexer"
+	"laptudirm.com/x/brainfuck/pkg/parser"
+	"laptudirm.com/x/brainfuck/pkg/reference"
+	"laptudirm.com/x/brainfuck/pkg/targets/internal/targettest"
+	"laptudirm.com/x/brainfuck/pkg/targets/opcode"
+)
+
+var seeds = []struct {
+	src   string
+	input string
+}{
+	{src: "++++++++[>++++[>++>+++>+++>+<<<<-]>+>+>->>+[<]<-]>>.>---.+++++++..+++.>>.<-.<.+++.------.--------.>>+.>++.", input: ""},
+	{src: ",[.,]", input: "echo"},
+	{src: "+>+<[-]>.", input: ""},
+	{src: ",[->++<]>.", input: "A"},
+	{src: "+[->,.<]>+.", input: ""},
+	{src: ",[>+.<[-]]+[->+.<]>.", input: "x"},
+	{src: ">>++[<+<[-]>>-]<<.", input: ""},
+}
+
+// tape is the size of the memory tape used while fuzzing. The pointer
+// starts in the middle of the tape.
+const tape = 256
+
+// FuzzOptimizer checks that the output and the final memory of programs
+// which are optimized and compiled to opcode are the same as that of the
+// reference interpreter.
+func FuzzOptimizer(f *testing.F) {
+	for _, seed := range seeds {
+		f.Add(targettest.Data(seed.src), []byte(seed.input))
+	}
+
+	f.Fuzz(func(t *testing.T, data, input []byte) {
+		src := targettest.Program(data)
+
+		var expected bytes.Buffer
+		ref := reference.Interpreter{
+			Memory:   make([]byte, tape),
+			Pointer:  tape / 2,
+			Input:    bytes.NewReader(input),
+			Output:   &expected,
+			MaxSteps: 100000,
+		}
+
+		if err := ref.Run(lexer.Lex(src)); err != nil {
+			// out of bounds or too slow, nothing to compare
+			t.Skip()
+		}
+
+		// the evaluator runs the optimized chunk directly, so differences
+		// between it and the reference are bugs in the optimizer, while
+		// differences only in the VM are bugs in opcode
+		output, memory := evaluate(t, src, input)
+		if !bytes.Equal(output, expected.Bytes()) {
+			t.Errorf("%s: expected output %q, received %q from the evaluator", src, expected.Bytes(), output)
+		}
+
+		if !bytes.Equal(memory, ref.Memory) {
+			t.Errorf("%s: expected memory %v, received %v from the evaluator", src, ref.Memory, memory)
+		}
+
+		// the final memory is only the same if trailing code is kept
+		output, memory = run(t, src, input, &instruction.ChunkBuilder{KeepTrailing: true})
+		if !bytes.Equal(output, expected.Bytes()) {
+			t.Errorf("%s: expected output %q, received %q", src, expected.Bytes(), output)
+		}
+
+		if !bytes.Equal(memory, ref.Memory) {
+			t.Errorf("%s: expected memory %v, received %v", src, ref.Memory, memory)
+		}
+
+		output, _ = run(t, src, input, &instruction.ChunkBuilder{})
+		if !bytes.Equal(output, expected.Bytes()) {
+			t.Errorf("%s: expected output %q, received %q without trailing code", src, expected.Bytes(), output)
+		}
+	})
+}
+
+// evaluate builds the given program keeping trailing code, and runs it on
+// the IR evaluator, returning the output and the final memory.
+func evaluate(t *testing.T, src, input []byte) ([]byte, []byte) {
+	t.Helper()
+
+	chunk, err := parser.ParseWith(lexer.Lex(src), &instruction.ChunkBuilder{KeepTrailing: true})
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+
+	var output bytes.Buffer
+	e := instruction.Evaluator{
+		Memory:   make([]byte, tape),
+		Pointer:  tape / 2,
+		Input:    bytes.NewReader(input),
+		Output:   &output,
+		MaxSteps: 1000000,
+	}
+
+	if err := e.Run(chunk); err != nil {
+		t.Fatalf("%s: %v\n%s", src, err, chunk)
+	}
+
+	return output.Bytes(), e.Memory
+}
+
+// run builds the given program using the given ChunkBuilder and runs it on
+// the opcode VM, returning the output and the final memory.
+func run(t *testing.T, src, input []byte, c *instruction.ChunkBuilder) ([]byte, []byte) {
+	t.Helper()
+
+	chunk, err := parser.ParseWith(lexer.Lex(src), c)
+	if err != nil {
+		t.Fatalf("%s: %v", src, err)
+	}
+
+	var output bytes.Buffer
+	vm := opcode.VM{
+		Memory:   make([]byte, tape),
+		Pointer:  tape / 2,
+		Input:    bytes.NewReader(input),
+		Output:   &output,
+		MaxSteps: 1000000,
+	}
+
+	// the optimized program must terminate if the reference did
+	if err := vm.Run(opcode.Compile(chunk)); err != nil {
+		t.Fatalf("%s: %v\n%s", src, err, chunk)
+	}
+
+	return output.Bytes(), vm.Memory
+}
+
+var boundsTests = []struct {
+	src string
+	err error
+}{
+	{src: "<,.", err: opcode.ErrOutOfBounds},
+	{src: "+[>+]", err: opcode.ErrOutOfBounds},
+	{src: ",[>+<-]>>>,.", err: opcode.ErrOutOfBounds},
+	{src: ">+[-<+>]<.", err: nil},
+
+	// the range of the loop includes -1, but it's never accessed
+	{src: "+[->[-<<+>>]<]", err: nil},
+}
+
+func TestBounds(t *testing.T) {
+	for _, test := range boundsTests {
+		chunk, err := parser.Parse(lexer.Lex([]byte(test.src)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		vm := opcode.VM{
+			Memory: make([]byte, 3),
+			Input:  bytes.NewReader(nil),
+			Output: &bytes.Buffer{},
+		}
+
+		if err := vm.Run(opcode.Compile(chunk)); err != test.err {
+			t.Errorf("%s: expected error %v, received %v", test.src, test.err, err)
+		}
+	}
+}
@@ -14,77 +14,240 @@
 package opcode
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 )
 
-// vm is a Virtual Machine which records the state of the brainfuck program
-// as opcode gets interpreted.
-type vm struct {
-	memory  []byte // memory tape
-	pointer int    // memory pointer
+// VM is a Virtual Machine which records the state of the brainfuck program
+// as opcode gets interpreted. The Memory, Input, and Output fields must be
+// set before calling Run.
+type VM struct {
+	Memory  []byte // memory tape
+	Pointer int    // memory pointer
+
+	Input  io.Reader // input stream, the cell is unchanged on EOF
+	Output io.Writer // output stream
+
+	// MaxSteps is the maximum number of opcodes which are executed before
+	// giving up, or 0 for no limit.
+	MaxSteps int
 }
 
-func Run(oc []int) {
+// Errors returned by Run.
+var (
+	ErrMaxSteps    = errors.New("opcode: maximum number of steps exceeded")
+	ErrOutOfBounds = errors.New("opcode: pointer out of bounds")
+)
+
+// Run runs the given opcode on a VM with 30000 cells of memory, which uses
+// the standard input and output streams.
+func Run(oc []int) error {
 	// TODO: make these options customizable
-	v := vm{memory: make([]byte, 30000)}
+	v := VM{
+		Memory: make([]byte, 30000),
+		Input:  os.Stdin,
+		Output: os.Stdout,
+	}
+
+	return v.Run(oc)
+}
+
+// Run runs the given opcode on the VM.
+func (v *VM) Run(oc []int) error {
+	input := bufio.NewReader(v.Input)
 	buffer := printBuffer{
-		writer:    os.Stdout,
+		writer:    v.Output,
 		autoFlush: true,
 		length:    50,
 	}
 
+	// checked reports whether accesses have to be bounds checked, which
+	// is not the case inside a CheckRange whose range is in bounds
+	checked := true
+	outOfBounds := func(pointer int) bool {
+		return checked && (pointer < 0 || pointer >= len(v.Memory))
+	}
+
+	steps := 0
 	length := len(oc)
 	for i := 0; i < length; i++ {
+		if steps++; v.MaxSteps > 0 && steps > v.MaxSteps {
+			return ErrMaxSteps
+		}
+
 		switch Opcode(oc[i]) {
 		case ChangeValue:
-			pointer := v.pointer + oc[i+1]     // calculate pointer offset
-			v.memory[pointer] += byte(oc[i+2]) // change value by amount
+			pointer := v.Pointer + oc[i+1] // calculate pointer offset
+			if outOfBounds(pointer) {
+				buffer.Flush()
+				return ErrOutOfBounds
+			}
+
+			v.Memory[pointer] += byte(oc[i+2]) // change value by amount
 			i += 2                             // update instruction pointer
 
 		case InputByte:
-			i++                                 // update instruction pointer
-			pointer := v.pointer + oc[i]        // calculate pointer offset
-			fmt.Scanf("%c", &v.memory[pointer]) // store input in memory
+			i++                          // update instruction pointer
+			pointer := v.Pointer + oc[i] // calculate pointer offset
+			if outOfBounds(pointer) {
+				buffer.Flush()
+				return ErrOutOfBounds
+			}
+
+			// flush any pending output, like prompts, before blocking
+			buffer.Flush()
+
+			// store input in memory, leaving it unchanged on EOF
+			b, err := input.ReadByte()
+			switch err {
+			case nil:
+				v.Memory[pointer] = b
+			case io.EOF:
+			default:
+				return err
+			}
 
 		case OutputByte:
-			i++                             // update instruction pointer
-			pointer := v.pointer + oc[i]    // calculate pointer offset
-			buffer.Write(v.memory[pointer]) // output current cell value
+			i++                          // update instruction pointer
+			pointer := v.Pointer + oc[i] // calculate pointer offset
+			if outOfBounds(pointer) {
+				buffer.Flush()
+				return ErrOutOfBounds
+			}
+
+			buffer.Write(v.Memory[pointer]) // output current cell value
+
+		case PrintByte:
+			i++                       // update instruction pointer
+			buffer.Write(byte(oc[i])) // output value
 
 		case JumpIfZero:
 			i++                // update instruction pointer
-			v.pointer += oc[i] // change pointer by offset
+			v.Pointer += oc[i] // change pointer by offset
+			if outOfBounds(v.Pointer) {
+				buffer.Flush()
+				return ErrOutOfBounds
+			}
 
 			i++           // update instruction pointer
 			jump := oc[i] // get jump offset
 
 			// jump if zero
-			if v.memory[v.pointer] == 0 {
+			if v.Memory[v.Pointer] == 0 {
 				i += jump
 			}
 
 		case JumpIfNotZero:
 			i++                // update instruction pointer
-			v.pointer += oc[i] // change pointer by offset
+			v.Pointer += oc[i] // change pointer by offset
+			if outOfBounds(v.Pointer) {
+				buffer.Flush()
+				return ErrOutOfBounds
+			}
+
+			i++           // update instruction pointer
+			jump := oc[i] // get jump offset
+
+			// jump back if not zero
+			if v.Memory[v.Pointer] != 0 {
+				i -= jump
+			}
+
+		case JumpIfZeroAt:
+			i++                          // update instruction pointer
+			pointer := v.Pointer + oc[i] // calculate pointer offset
+			if outOfBounds(pointer) {
+				buffer.Flush()
+				return ErrOutOfBounds
+			}
+
+			i++           // update instruction pointer
+			jump := oc[i] // get jump offset
+
+			// jump if zero
+			if v.Memory[pointer] == 0 {
+				i += jump
+			}
+
+		case JumpIfNotZeroAt:
+			i++                          // update instruction pointer
+			pointer := v.Pointer + oc[i] // calculate pointer offset
+			if outOfBounds(pointer) {
+				buffer.Flush()
+				return ErrOutOfBounds
+			}
 
 			i++           // update instruction pointer
 			jump := oc[i] // get jump offset
 
 			// jump back if not zero
-			if v.memory[v.pointer] != 0 {
+			if v.Memory[pointer] != 0 {
 				i -= jump
 			}
 
+		case SkipIfZero:
+			i++                          // update instruction pointer
+			pointer := v.Pointer + oc[i] // calculate pointer offset
+			if outOfBounds(pointer) {
+				buffer.Flush()
+				return ErrOutOfBounds
+			}
+
+			i++           // update instruction pointer
+			jump := oc[i] // get jump offset
+
+			// skip block if zero
+			if v.Memory[pointer] == 0 {
+				i += jump
+			}
+
 		case SetValue:
 			i++                          // update instruction pointer
-			pointer := v.pointer + oc[i] // calculate pointer offset
+			pointer := v.Pointer + oc[i] // calculate pointer offset
+			if outOfBounds(pointer) {
+				buffer.Flush()
+				return ErrOutOfBounds
+			}
 
 			i++                  // update instruction pointer
 			value := byte(oc[i]) // get set value
 
-			v.memory[pointer] = value // clear current cell
+			v.Memory[pointer] = value // clear current cell
+
+		case MulValue:
+			if outOfBounds(v.Pointer + oc[i+2]) {
+				buffer.Flush()
+				return ErrOutOfBounds
+			}
+
+			source := v.Memory[v.Pointer+oc[i+2]] // get source value
+
+			// the source is zero when the original loop is never run, so
+			// the destination, which may be out of bounds, isn't touched
+			if source != 0 {
+				pointer := v.Pointer + oc[i+1] // calculate pointer offset
+				if outOfBounds(pointer) {
+					buffer.Flush()
+					return ErrOutOfBounds
+				}
+
+				v.Memory[pointer] += source * byte(oc[i+3]) // change value by amount times source
+			}
+
+			i += 3 // update instruction pointer
+
+		case CheckRange:
+			// the cells from min to max are all the cells which may be
+			// accessed until the next EndRange
+			min, max := v.Pointer+oc[i+1], v.Pointer+oc[i+2]
+			checked = min < 0 || max >= len(v.Memory)
+			i += 2 // update instruction pointer
+
+		case EndRange:
+			checked = true
 
 		default:
 			panic(fmt.Sprintf("opcode: run: invalid opcode %x", uint(oc[i])))
@@ -93,12 +256,14 @@ func Run(oc []int) {
 
 	// flush any remaining output
 	buffer.Flush()
+	return buffer.err
 }
 
 // printBuffer is a helper struct which buffers byte outputs for better
 // performance, as syscalls are expensive.
 type printBuffer struct {
 	buffer []byte // backlog
+	err    error  // first error while writing
 
 	// options
 	writer    io.Writer // writer to output to
@@ -123,6 +288,9 @@ func (b *printBuffer) Flush() {
 		return
 	}
 
-	b.writer.Write(b.buffer)
+	if _, err := b.writer.Write(b.buffer); err != nil && b.err == nil {
+		b.err = err
+	}
+
 	b.buffer = []byte{}
 }
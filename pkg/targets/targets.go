@@ -0,0 +1,56 @@
+// Copyright © 2022 Rak Laptudirm <raklaptudirm@gmail.com>
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package targets defines the options which are common to the compilation
+// targets, which are implemented by it's subpackages.
+package targets
+
+import "fmt"
+
+// DefaultTapeSize is the number of cells of memory used by compiled
+// programs, unless configured otherwise.
+const DefaultTapeSize = 30000
+
+// EOF represents what a compiled program stores in a cell when it reads
+// input at the end of the input stream.
+type EOF int
+
+// Various EOF rules.
+const (
+	EOFUnchanged EOF = iota // the cell is left unchanged
+	EOFZero                 // the cell is set to 0
+	EOFMinusOne             // the cell is set to -1, i.e. 255
+)
+
+var eofs = [...]string{
+	EOFUnchanged: "unchanged",
+	EOFZero:      "zero",
+	EOFMinusOne:  "minus-one",
+}
+
+// String returns the name of the EOF rule, as accepted by ParseEOF.
+func (e EOF) String() string {
+	return eofs[e]
+}
+
+// ParseEOF returns the EOF rule with the given name, which is one of
+// unchanged, zero, and minus-one.
+func ParseEOF(name string) (EOF, error) {
+	for e, s := range eofs {
+		if s == name {
+			return EOF(e), nil
+		}
+	}
+
+	return 0, fmt.Errorf("targets: unknown EOF rule %q", name)
+}
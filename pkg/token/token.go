@@ -63,8 +63,8 @@ func (t Type) String() string {
 
 // Position represents the position of a token in a file.
 type Position struct {
-	Line   int
-	Column int
+	Line   int `json:"line"`
+	Column int `json:"column"`
 }
 
 // String returns a string representation of a position in the format
@@ -73,6 +73,33 @@ func (p Position) String() string {
 	return fmt.Sprintf("%d:%d", p.Line, p.Column)
 }
 
+// Before checks if the position is before the position q.
+func (p Position) Before(q Position) bool {
+	if p.Line != q.Line {
+		return p.Line < q.Line
+	}
+
+	return p.Column < q.Column
+}
+
+// Span represents a range of source code, from the position of it's first
+// character to the position of it's last character.
+type Span struct {
+	Start Position `json:"start"`
+	End   Position `json:"end"`
+}
+
+// String returns a string representation of a span in the format
+// <line>:<column>-<line>:<column>, or <line>:<column> for a span of a
+// single character.
+func (s Span) String() string {
+	if s.Start == s.End {
+		return s.Start.String()
+	}
+
+	return fmt.Sprintf("%s-%s", s.Start, s.End)
+}
+
 // NextLine moves the position to the next line.
 func (p *Position) NextLine() {
 	p.Line++